@@ -0,0 +1,207 @@
+package main
+
+import (
+	"log"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// accessEntry is one blocklist or allowlist entry: a single IP or a
+// CIDR. Expires is a wall-time deadline in nanoseconds; zero means the
+// entry is permanent.
+type accessEntry struct {
+	Key     string    `json:"ip"`
+	Expires uint64    `json:"expires,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Added   time.Time `json:"added"`
+}
+
+// accessList is a set of IPs and CIDRs matched against player
+// addresses, the same way Router matches prefix routes.
+type accessList struct {
+	entries  map[string]accessEntry
+	prefixes []netip.Prefix // CIDR keys of entries, longest first
+}
+
+func newAccessList() *accessList {
+	return &accessList{entries: make(map[string]accessEntry)}
+}
+
+// canonicalAccessKey normalizes an IP or CIDR ("10.1.2.3/8" becomes
+// "10.0.0.0/8") and reports false when it is neither.
+func canonicalAccessKey(key string) (string, bool) {
+	if routeKind(key) == routePrefix {
+		return canonicalRouteKey(key)
+	}
+	a, err := netip.ParseAddr(key)
+	if err != nil {
+		return "", false
+	}
+	return a.Unmap().String(), true
+}
+
+// Set adds or replaces e.
+func (l *accessList) Set(e accessEntry) {
+	_, existed := l.entries[e.Key]
+	l.entries[e.Key] = e
+	if !existed && routeKind(e.Key) == routePrefix {
+		l.reindex()
+	}
+}
+
+// Delete removes key and reports whether it was present.
+func (l *accessList) Delete(key string) bool {
+	if _, ok := l.entries[key]; !ok {
+		return false
+	}
+	delete(l.entries, key)
+	if routeKind(key) == routePrefix {
+		l.reindex()
+	}
+	return true
+}
+
+func (l *accessList) reindex() {
+	l.prefixes = l.prefixes[:0]
+	for key := range l.entries {
+		if p, err := netip.ParsePrefix(key); err == nil {
+			l.prefixes = append(l.prefixes, p)
+		}
+	}
+	sort.Slice(l.prefixes, func(i, j int) bool {
+		return l.prefixes[i].Bits() > l.prefixes[j].Bits()
+	})
+}
+
+// Match returns the live entry covering ip (a bare IP or a flow) at now.
+func (l *accessList) Match(ip string, now uint64) (accessEntry, bool) {
+	if len(l.entries) == 0 {
+		return accessEntry{}, false
+	}
+	addr, ok := parsePlayerAddr(ip)
+	if !ok {
+		return accessEntry{}, false
+	}
+	live := func(e accessEntry) bool { return e.Expires == 0 || now < e.Expires }
+	if e, ok := l.entries[addr.String()]; ok && live(e) {
+		return e, true
+	}
+	for _, p := range l.prefixes {
+		if e := l.entries[p.String()]; p.Contains(addr) && live(e) {
+			return e, true
+		}
+	}
+	return accessEntry{}, false
+}
+
+// Expire removes entries whose deadline is at or before now and
+// returns their keys.
+func (l *accessList) Expire(now uint64) []string {
+	var evicted []string
+	for key, e := range l.entries {
+		if e.Expires != 0 && e.Expires <= now {
+			l.Delete(key)
+			evicted = append(evicted, key)
+		}
+	}
+	return evicted
+}
+
+// List returns every entry sorted by key.
+func (l *accessList) List() []accessEntry {
+	out := make([]accessEntry, 0, len(l.entries))
+	for _, e := range l.entries {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// Access-control drop reasons, used as the "list" label on
+// peel_access_dropped_total.
+const (
+	accessBlocked    = "blocklist"
+	accessNotAllowed = "allowlist"
+)
+
+// accessAllowed reports whether packets from playerIP may enter at now:
+// it must not be on the blocklist and, when the allowlist has entries,
+// must be on it.
+func (r *Relay) accessAllowed(playerIP string, now uint64) bool {
+	if _, blocked := r.blocklist.Match(playerIP, now); blocked {
+		r.metrics.accessDropped[accessBlocked]++
+		return false
+	}
+	if len(r.allowlist.entries) > 0 {
+		if _, ok := r.allowlist.Match(playerIP, now); !ok {
+			r.metrics.accessDropped[accessNotAllowed]++
+			return false
+		}
+	}
+	return true
+}
+
+// Block adds key (an IP or CIDR) to the blocklist until expires
+// (wall-time nanoseconds; zero means permanently) and closes the
+// sessions it now covers.
+func (r *Relay) Block(key string, expires uint64, reason string) {
+	r.blocklist.Set(accessEntry{Key: key, Expires: expires, Reason: reason, Added: time.Now().UTC()})
+	log.Printf("Blocklist add: %s (%s)", key, reason)
+	r.enforceAccess()
+}
+
+// Allow adds key (an IP or CIDR) to the allowlist until expires. The
+// first allowlist entry turns the allowlist on, closing the sessions of
+// every player not on it.
+func (r *Relay) Allow(key string, expires uint64, reason string) {
+	r.allowlist.Set(accessEntry{Key: key, Expires: expires, Reason: reason, Added: time.Now().UTC()})
+	log.Printf("Allowlist add: %s (%s)", key, reason)
+	r.enforceAccess()
+}
+
+// Unblock removes key from the blocklist.
+func (r *Relay) Unblock(key string) bool {
+	ok := r.blocklist.Delete(key)
+	if ok {
+		log.Printf("Blocklist remove: %s", key)
+	}
+	return ok
+}
+
+// Disallow removes key from the allowlist, closing the sessions that
+// are no longer allowed.
+func (r *Relay) Disallow(key string) bool {
+	ok := r.allowlist.Delete(key)
+	if ok {
+		log.Printf("Allowlist remove: %s", key)
+		r.enforceAccess()
+	}
+	return ok
+}
+
+// enforceAccess closes every session whose player is no longer allowed
+// in.
+func (r *Relay) enforceAccess() {
+	now := uint64(time.Now().UnixNano())
+	for flow := range r.sessions {
+		_, blocked := r.blocklist.Match(flow, now)
+		_, allowed := r.allowlist.Match(flow, now)
+		if blocked || (len(r.allowlist.entries) > 0 && !allowed) {
+			r.closeSessionLocked(flow, closeBlocked)
+		}
+	}
+}
+
+// SweepAccess runs once per step and drops expired list entries.
+func (r *Relay) SweepAccess(wallNanos uint64) {
+	for _, key := range r.blocklist.Expire(wallNanos) {
+		log.Printf("Blocklist entry expired: %s", key)
+	}
+	if expired := r.allowlist.Expire(wallNanos); len(expired) > 0 {
+		for _, key := range expired {
+			log.Printf("Allowlist entry expired: %s", key)
+		}
+		r.enforceAccess()
+	}
+}
@@ -2,92 +2,344 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
-	"github.com/BananaLabs-OSS/Fiber/pulp/gin/middleware"
 )
 
 // registerRoutes wires the HTTP control API. Bananasplit pushes route
-// changes here; operators can use GET /health and GET /routes for
-// observability.
-//
-// Auth posture: auth-available-not-mandatory. The three state-mutating
-// endpoints (POST /routes, DELETE /routes/:ip, DELETE /sessions/:ip) are
-// gated on the X-Service-Token shared secret — the same SERVICE_TOKEN
-// pattern Bananagine/Bananauth use — ONLY when serviceToken is non-empty.
-// When the token is empty (the default today), the mutating routes are
-// registered WITHOUT the auth middleware so the existing callers
-// (Bananasplit PeelClient, Potassium relay.Client), which send no
-// X-Service-Token, keep working — no 401, no outage. The control API is
-// internal-only-bounded (the cell publishes only the UDP listener), so an
-// unauthenticated control port is reachable only from sibling cells on the
-// Pulp host. To ENABLE auth: set SERVICE_TOKEN here AND have the callers
-// send X-Service-Token, in lockstep. The GET observability routes
-// (/routes, /health) are always open intentionally.
-func registerRoutes(r *pulpgin.Engine, relay *Relay, serviceToken string) {
-	// Mutating routes ride a root group. The empty group prefix keeps the
-	// paths identical to native Peel; only the auth middleware (when a
-	// token is configured) is interposed.
-	var mutating *pulpgin.RouterGroup
-	if serviceToken != "" {
-		mutating = r.Group("", middleware.ServiceAuth(serviceToken))
-	} else {
-		mutating = r.Group("")
-	}
-	mutating.POST("/routes", setRoute(relay))
-	mutating.DELETE("/routes/:playerIP", deleteRoute(relay))
-	mutating.DELETE("/sessions/:playerIP", closeSession(relay))
-
-	r.GET("/routes", listRoutes(relay))
-	r.GET("/health", health)
+// changes here; operators can use GET /health, GET /routes,
+// GET /sessions and GET /metrics for observability, other services
+// can replay changes from GET /events, and GET /audit shows who changed
+// what.
+//
+// Auth posture: auth-available-not-mandatory. Each state-mutating
+// endpoint requires an X-Service-Token whose token carries the scope
+// it needs — route-write for /routes, /pools and /negative-cache,
+// session-admin for DELETE /sessions/:ip, /blocklist and /allowlist —
+// ONLY when at least one token is configured (SERVICE_TOKEN or
+// service_tokens; see tokenAuth). With JWT auth configured, a bearer
+// JWT carrying the scope works too, and its peel_players claim limits
+// which players the handlers let it touch (callerOf). With neither
+// configured (the default today) every call passes, so the existing
+// callers (Bananasplit PeelClient, Potassium relay.Client), which send
+// no X-Service-Token, keep working — no 401, no outage. The control
+// API is internal-only-bounded (the cell publishes only the UDP
+// listener), so an unauthenticated control port is reachable only from
+// sibling cells on the Pulp host. The GET observability routes stay
+// open unless auth_reads is set; /health is always open so probes need
+// no secret.
+func registerRoutes(r *pulpgin.Engine, relay *Relay, auth *tokenAuth) {
+	routeWrite := func(call string, h pulpgin.HandlerFunc) pulpgin.HandlerFunc {
+		return auth.require(scopeRouteWrite, call, h)
+	}
+	sessionAdmin := func(call string, h pulpgin.HandlerFunc) pulpgin.HandlerFunc {
+		return auth.require(scopeSessionAdmin, call, h)
+	}
+
+	r.POST("/routes", routeWrite("POST /routes", setRoute(relay)))
+	r.POST("/routes/batch", routeWrite("POST /routes/batch", setRoutesBatch(relay)))
+	r.DELETE("/routes", routeWrite("DELETE /routes", deleteRoutesBatch(relay)))
+	r.DELETE("/routes/:playerIP", routeWrite("DELETE /routes/:playerIP", deleteRoute(relay)))
+	r.DELETE("/negative-cache", routeWrite("DELETE /negative-cache", purgeNegativeCache(relay)))
+	r.DELETE("/negative-cache/:playerIP", routeWrite("DELETE /negative-cache/:playerIP", purgeNegativeCache(relay)))
+	r.POST("/pools", routeWrite("POST /pools", setPool(relay)))
+	r.DELETE("/pools/:name", routeWrite("DELETE /pools/:name", deletePool(relay)))
+
+	r.DELETE("/sessions/:playerIP", sessionAdmin("DELETE /sessions/:playerIP", closeSession(relay)))
+	r.POST("/blocklist", sessionAdmin("POST /blocklist", addAccess(relay, auditBlocklistAdd, relay.Block)))
+	r.DELETE("/blocklist", sessionAdmin("DELETE /blocklist", removeAccess(relay, auditBlocklistRemove, relay.Unblock)))
+	r.DELETE("/blocklist/:ip", sessionAdmin("DELETE /blocklist/:ip", removeAccess(relay, auditBlocklistRemove, relay.Unblock)))
+	r.POST("/allowlist", sessionAdmin("POST /allowlist", addAccess(relay, auditAllowlistAdd, relay.Allow)))
+	r.DELETE("/allowlist", sessionAdmin("DELETE /allowlist", removeAccess(relay, auditAllowlistRemove, relay.Disallow)))
+	r.DELETE("/allowlist/:ip", sessionAdmin("DELETE /allowlist/:ip", removeAccess(relay, auditAllowlistRemove, relay.Disallow)))
+
+	r.GET("/routes", auth.read(listRoutes(relay)))
+	r.GET("/sessions", auth.read(listSessions(relay)))
+	r.GET("/sessions/:playerIP", auth.read(getSessions(relay)))
+	r.GET("/health", health(relay))
+	r.GET("/metrics", auth.read(metrics(relay, auth)))
+	r.GET("/events", auth.read(streamEvents(relay)))
+	r.GET("/backends", auth.read(listBackends(relay)))
+	r.GET("/pools", auth.read(listPools(relay)))
+	r.GET("/negative-cache", auth.read(listNegativeCache(relay)))
+	r.GET("/blocklist", auth.read(listAccess(relay.blocklist)))
+	r.GET("/allowlist", auth.read(listAccess(relay.allowlist)))
+	r.GET("/audit", auth.read(listAudit(relay)))
 }
 
 // POST /routes
 // {"player_ip": "203.0.113.50", "backend": "10.0.50.2:5521"}
 //
+// player_ip is either a bare IP (every flow from that address) or a
+// full "ip:port" flow, which overrides the IP route for that one player
+// behind a shared NAT. It may also be a CIDR ("10.0.0.0/8") for a prefix
+// route, or "default" for the catch-all route that unknown players use
+// instead of asking Bananasplit.
+//
+// An optional "ttl" (Go duration, e.g. "30m") makes the route expire;
+// without it the route lives until deleted. An optional
+// "proxy_protocol" ("off", "header", "preamble") overrides the global
+// PROXY v2 mode for sessions on this route.
+//
 // Error responses match native Peel's http.Error shape (plain text body,
 // trailing newline) so parity clients comparing against the native
 // stdlib handler see byte-identical responses.
 func setRoute(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		var req routeRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.String(400, "invalid json\n")
+			return
+		}
+		w, msg := req.validate(relay)
+		if msg != "" {
+			c.String(400, msg+"\n")
+			return
+		}
+		if !callerOf(c).mayMutate(w.PlayerIP) {
+			c.String(403, "forbidden\n")
+			return
+		}
+		old, changed, _ := applyRoute(relay, w)
+		auditRoute(relay, auditFrom(c), w, old, changed)
+		writeJSONWithNewline(c, 200, pulpgin.H{"status": "ok"})
+	}
+}
+
+// routeRequest is one route in a POST /routes or POST /routes/batch
+// body.
+type routeRequest struct {
+	PlayerIP string `json:"player_ip"`
+	Backend  string `json:"backend"`
+	TTL      string `json:"ttl"`
+	Proxy    string `json:"proxy_protocol"`
+}
+
+// routeWrite is a validated routeRequest.
+type routeWrite struct {
+	PlayerIP string
+	Backend  string
+	TTL      time.Duration
+	Proxy    string
+}
+
+// validate checks req and returns the write to apply, or a non-empty
+// native-style error message (no trailing newline).
+func (req routeRequest) validate(relay *Relay) (routeWrite, string) {
+	if req.PlayerIP == "" || req.Backend == "" {
+		return routeWrite{}, "player_ip and backend required"
+	}
+	key, ok := canonicalRouteKey(req.PlayerIP)
+	if !ok {
+		return routeWrite{}, "invalid player_ip"
+	}
+	// Validate the backend on the first-write/create path too. Native
+	// Peel rejects malformed backends via net.ResolveUDPAddr before
+	// storing; the cell mirrors that with the same checkTarget
+	// UpdateSessionBackend uses on the change path, so a garbage or
+	// malicious address can never be persisted as a route target. On
+	// top of the syntax check it enforces the backend destination
+	// policy; a "pool:<name>" backend must name a configured pool.
+	if err := relay.checkTarget(req.Backend); err != nil {
+		return routeWrite{}, err.Error()
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			return routeWrite{}, "invalid ttl"
+		}
+		ttl = d
+	}
+	if !validProxyMode(req.Proxy) {
+		return routeWrite{}, "invalid proxy_protocol"
+	}
+	return routeWrite{PlayerIP: key, Backend: req.Backend, TTL: ttl, Proxy: req.Proxy}, ""
+}
+
+// applyRoute stores w and rebinds live sessions when the effective
+// backend changed. Returns the backend the key resolved to before (""
+// when none), whether it changed and which session flows were rebound
+// (hot-swapped, or closed when hot_swap is off).
+func applyRoute(relay *Relay, w routeWrite) (old string, changed bool, rebound []string) {
+	// A new exact route changes the effective backend even when only a
+	// broader route (IP, prefix, default) covered it before, so compare
+	// against Lookup. A new prefix or default route only applies to new
+	// sessions; changing an existing one rebinds the sessions it covers.
+	oldBackend, hadRoute := relay.Router().Get(w.PlayerIP)
+	if routeKind(w.PlayerIP) == routeExact {
+		oldBackend, hadRoute = relay.Router().Lookup(w.PlayerIP)
+	}
+	relay.Router().SetEntry(w.PlayerIP, routeEntry{
+		Backend: w.Backend,
+		Expires: expiryAfter(w.TTL),
+		Proxy:   w.Proxy,
+	})
+
+	if hadRoute && oldBackend != w.Backend {
+		rebound = relay.UpdateSessionBackend(w.PlayerIP, w.Backend)
+		log.Printf("Route changed: %s %s → %s", w.PlayerIP, oldBackend, w.Backend)
+		relay.emit(relayEvent{Type: evRouteChanged, PlayerIP: w.PlayerIP, Backend: w.Backend, OldBackend: oldBackend})
+		return oldBackend, true, rebound
+	}
+	log.Printf("Route set: %s → %s", w.PlayerIP, w.Backend)
+	relay.emit(relayEvent{Type: evRouteSet, PlayerIP: w.PlayerIP, Backend: w.Backend})
+	return oldBackend, false, nil
+}
+
+// auditRoute records the outcome of applyRoute for an API write.
+func auditRoute(relay *Relay, a auditEntry, w routeWrite, old string, changed bool) {
+	action := auditRouteSet
+	if changed {
+		action = auditRouteChanged
+	}
+	relay.audit.Record(a.of(action, w.PlayerIP, old, w.Backend))
+}
+
+// POST /routes/batch
+// {"routes": [{"player_ip": "...", "backend": "...", "ttl": "..."}, ...]}
+//
+// All or nothing: every entry is validated first, and if any is
+// invalid (or a player_ip repeats) nothing is applied and the 400 body
+// lists the per-entry errors; the status is 403 instead when an entry
+// is outside the player IPs the caller may change. On success each
+// result reports whether the route changed and which sessions were
+// rebound to the new backend.
+func setRoutesBatch(relay *Relay) pulpgin.HandlerFunc {
 	return func(c *pulpgin.Context) {
 		var req struct {
-			PlayerIP string `json:"player_ip"`
-			Backend  string `json:"backend"`
+			Routes []routeRequest `json:"routes"`
 		}
 		if err := c.BindJSON(&req); err != nil {
 			c.String(400, "invalid json\n")
 			return
 		}
-		if req.PlayerIP == "" || req.Backend == "" {
-			c.String(400, "player_ip and backend required\n")
+		if len(req.Routes) == 0 {
+			c.String(400, "routes required\n")
 			return
 		}
-		// Validate the backend on the first-write/create path too. Native
-		// Peel rejects malformed backends via net.ResolveUDPAddr before
-		// storing; the cell mirrors that with the same validBackendAddr
-		// check UpdateSessionBackend uses on the change path, so a garbage
-		// or malicious address can never be persisted as a route target.
-		if !validBackendAddr(req.Backend) {
-			c.String(400, "invalid backend address\n")
+
+		writes := make([]routeWrite, len(req.Routes))
+		results := make([]batchResult, len(req.Routes))
+		seen := make(map[string]bool, len(req.Routes))
+		cl := callerOf(c)
+		failed, forbidden := false, false
+		for i, rr := range req.Routes {
+			results[i].PlayerIP = rr.PlayerIP
+			w, msg := rr.validate(relay)
+			if msg == "" && seen[w.PlayerIP] {
+				msg = "duplicate player_ip"
+			}
+			if msg == "" && !cl.mayMutate(w.PlayerIP) {
+				msg = "forbidden"
+				forbidden = true
+			}
+			seen[w.PlayerIP] = true
+			if msg != "" {
+				results[i].Error = msg
+				failed = true
+				continue
+			}
+			writes[i] = w
+		}
+		if failed {
+			status := 400
+			if forbidden {
+				status = 403
+			}
+			writeJSONWithNewline(c, status, pulpgin.H{"status": "error", "results": results})
 			return
 		}
 
-		oldBackend, hadRoute := relay.Router().Get(req.PlayerIP)
-		relay.Router().Set(req.PlayerIP, req.Backend)
+		a := auditFrom(c)
+		for i, w := range writes {
+			old, changed, rebound := applyRoute(relay, w)
+			auditRoute(relay, a, w, old, changed)
+			results[i].Status = "set"
+			if changed {
+				results[i].Status = "changed"
+			}
+			results[i].Sessions = rebound
+		}
+		log.Printf("Route batch applied: %d routes", len(writes))
+		writeJSONWithNewline(c, 200, pulpgin.H{"status": "ok", "hot_swap": relay.hotSwap, "results": results})
+	}
+}
 
-		if hadRoute && oldBackend != req.Backend {
-			relay.UpdateSessionBackend(req.PlayerIP, req.Backend)
-			log.Printf("Route changed: %s %s → %s", req.PlayerIP, oldBackend, req.Backend)
-		} else {
-			log.Printf("Route set: %s → %s", req.PlayerIP, req.Backend)
+// DELETE /routes
+// {"player_ips": ["203.0.113.50", "203.0.113.51:40000"]}
+//
+// Batch form of DELETE /routes/:playerIP. Each route is removed and its
+// sessions closed; unknown entries are left untouched and reported as
+// "not_found". This is also how prefix routes are deleted, since a CIDR
+// can't travel as a path segment. Entries outside the player IPs the
+// caller may change are left alone and reported as "forbidden".
+func deleteRoutesBatch(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		var req struct {
+			PlayerIPs []string `json:"player_ips"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.String(400, "invalid json\n")
+			return
+		}
+		if len(req.PlayerIPs) == 0 {
+			c.String(400, "player_ips required\n")
+			return
+		}
+		for _, ip := range req.PlayerIPs {
+			if ip == "" {
+				c.String(400, "player_ip required\n")
+				return
+			}
 		}
 
-		writeJSONWithNewline(c, 200, pulpgin.H{"status": "ok"})
+		results := make([]batchResult, len(req.PlayerIPs))
+		cl := callerOf(c)
+		a := auditFrom(c)
+		for i, ip := range req.PlayerIPs {
+			results[i].PlayerIP = ip
+			if key, ok := canonicalRouteKey(ip); ok {
+				ip = key
+			}
+			if !cl.mayMutate(ip) {
+				results[i].Status = "forbidden"
+				continue
+			}
+			old, ok := relay.Router().Get(ip)
+			if !ok {
+				results[i].Status = "not_found"
+				continue
+			}
+			results[i].Status = "deleted"
+			results[i].Sessions = relay.sessionKeys(ip)
+			relay.DeleteRoute(ip, "api")
+			relay.audit.Record(a.of(auditRouteDeleted, ip, old, ""))
+		}
+		writeJSONWithNewline(c, 200, pulpgin.H{"status": "ok", "results": results})
 	}
 }
 
+// batchResult is the per-entry outcome of a batch route call.
+type batchResult struct {
+	PlayerIP string   `json:"player_ip"`
+	Status   string   `json:"status,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Sessions []string `json:"sessions,omitempty"`
+}
+
 // DELETE /routes/:playerIP
+//
+// Accepts a bare IP, an "ip:port" flow or "default". Deleting an IP
+// route closes every session from that IP; deleting a flow route closes
+// only that flow; deleting the default route closes the sessions that
+// were using it.
 func deleteRoute(relay *Relay) pulpgin.HandlerFunc {
 	return func(c *pulpgin.Context) {
 		playerIP := c.Param("playerIP")
@@ -95,14 +347,23 @@ func deleteRoute(relay *Relay) pulpgin.HandlerFunc {
 			c.String(400, "player_ip required\n")
 			return
 		}
-		relay.Router().Delete(playerIP)
-		relay.CloseSession(playerIP)
-		log.Printf("Route deleted: %s", playerIP)
+		if !callerOf(c).mayMutate(playerIP) {
+			c.String(403, "forbidden\n")
+			return
+		}
+		old, ok := relay.Router().Get(playerIP)
+		relay.DeleteRoute(playerIP, "api")
+		if ok {
+			relay.audit.Record(auditFrom(c).of(auditRouteDeleted, playerIP, old, ""))
+		}
 		writeJSONWithNewline(c, 200, pulpgin.H{"status": "ok"})
 	}
 }
 
 // DELETE /sessions/:playerIP
+//
+// Accepts a bare IP (closes every flow from it) or an "ip:port" flow
+// (closes just that one).
 func closeSession(relay *Relay) pulpgin.HandlerFunc {
 	return func(c *pulpgin.Context) {
 		playerIP := c.Param("playerIP")
@@ -110,8 +371,17 @@ func closeSession(relay *Relay) pulpgin.HandlerFunc {
 			c.String(400, "player_ip required\n")
 			return
 		}
-		relay.CloseSession(playerIP)
+		if !callerOf(c).mayMutate(playerIP) {
+			c.String(403, "forbidden\n")
+			return
+		}
+		closing := relay.Sessions(playerIP)
+		relay.CloseSession(playerIP, closeAPI)
 		log.Printf("Session closed via API: %s", playerIP)
+		a := auditFrom(c)
+		for _, s := range closing {
+			relay.audit.Record(a.of(auditSessionClosed, s.PlayerAddr, s.Backend, ""))
+		}
 		writeJSONWithNewline(c, 200, pulpgin.H{"status": "ok"})
 	}
 }
@@ -121,9 +391,18 @@ func closeSession(relay *Relay) pulpgin.HandlerFunc {
 // Native sets Content-Type "application/json" explicitly (no charset).
 // We set it manually to match, then write the body with the same
 // trailing newline json.NewEncoder produces on native.
+//
+// The default body stays native's flat {"ip": "backend"} map. With
+// ?detail=1 each value becomes {"backend", "kind", "expires_in"}, where
+// kind is "exact", "prefix" or "default" and expires_in is the
+// remaining lifetime in seconds (omitted for routes that never expire).
 func listRoutes(relay *Relay) pulpgin.HandlerFunc {
 	return func(c *pulpgin.Context) {
-		body, err := json.Marshal(relay.Router().List())
+		var out any = relay.Router().List()
+		if c.Query("detail") != "" {
+			out = routeDetails(relay.Router().Entries(), uint64(time.Now().UnixNano()))
+		}
+		body, err := json.Marshal(out)
 		if err != nil {
 			c.String(500, "marshal error: %v", err)
 			return
@@ -133,6 +412,336 @@ func listRoutes(relay *Relay) pulpgin.HandlerFunc {
 	}
 }
 
+// routeDetail is one entry of GET /routes?detail=1. Kind is "exact",
+// "prefix" or "default".
+type routeDetail struct {
+	Backend   string `json:"backend"`
+	Kind      string `json:"kind"`
+	ExpiresIn *int64 `json:"expires_in,omitempty"`
+}
+
+func routeDetails(entries map[string]routeEntry, now uint64) map[string]routeDetail {
+	out := make(map[string]routeDetail, len(entries))
+	for k, e := range entries {
+		d := routeDetail{Backend: e.Backend, Kind: routeKind(k)}
+		if e.Expires != 0 {
+			var secs int64
+			if e.Expires > now {
+				secs = int64((e.Expires - now) / uint64(time.Second))
+			}
+			d.ExpiresIn = &secs
+		}
+		out[k] = d
+	}
+	return out
+}
+
+// GET /sessions
+//
+// Lists every active session with its traffic counters and the local
+// address of its outbound socket.
+func listSessions(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		writeJSONWithNewline(c, 200, relay.Sessions(""))
+	}
+}
+
+// GET /sessions/:playerIP
+//
+// Same shape as GET /sessions, filtered to one flow ("ip:port") or to
+// every flow of a bare IP. 404 when nothing matches.
+func getSessions(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		playerIP := c.Param("playerIP")
+		if playerIP == "" {
+			c.String(400, "player_ip required\n")
+			return
+		}
+		sessions := relay.Sessions(playerIP)
+		if len(sessions) == 0 {
+			c.String(404, "session not found\n")
+			return
+		}
+		writeJSONWithNewline(c, 200, sessions)
+	}
+}
+
+// GET /backends
+//
+// Health-check state of every backend the route table points at, so
+// Bananasplit can reroute players off unhealthy ones. Empty when
+// health_check is "off".
+func listBackends(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		writeJSONWithNewline(c, 200, relay.health.List())
+	}
+}
+
+// GET /negative-cache
+//
+// IPs whose route lookup failed, with the seconds left until their
+// packets trigger a lookup again and the consecutive failure count that
+// sets the backoff.
+func listNegativeCache(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		writeJSONWithNewline(c, 200, relay.negativeCache.List(time.Now().UnixNano()))
+	}
+}
+
+// DELETE /negative-cache
+// DELETE /negative-cache/:playerIP
+//
+// Forgets one IP, or every IP without a path parameter, so the next
+// packet asks Bananasplit right away — e.g. after fixing Bananasplit.
+// Purging every IP needs a caller without a player restriction.
+func purgeNegativeCache(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		playerIP := c.Param("playerIP")
+		cl := callerOf(c)
+		if (playerIP == "" && !cl.unrestricted()) || (playerIP != "" && !cl.mayMutate(playerIP)) {
+			c.String(403, "forbidden\n")
+			return
+		}
+		n := relay.PurgeNegativeCache(playerIP)
+		if n > 0 {
+			a := auditFrom(c).of(auditNegativePurged, playerIP, "", "")
+			a.Detail = fmt.Sprintf("%d entries", n)
+			relay.audit.Record(a)
+		}
+		writeJSONWithNewline(c, 200, pulpgin.H{"status": "ok", "purged": n})
+	}
+}
+
+// GET /blocklist
+// GET /allowlist
+//
+// Every entry of the list, sorted by IP/CIDR, as
+// {"ip", "reason", "added", "expires_in"}; expires_in is in seconds and
+// omitted for permanent entries.
+func listAccess(l *accessList) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		now := uint64(time.Now().UnixNano())
+		entries := l.List()
+		out := make([]pulpgin.H, len(entries))
+		for i, e := range entries {
+			out[i] = pulpgin.H{"ip": e.Key, "reason": e.Reason, "added": e.Added}
+			if e.Expires != 0 {
+				var secs int64
+				if e.Expires > now {
+					secs = int64((e.Expires - now) / uint64(time.Second))
+				}
+				out[i]["expires_in"] = secs
+			}
+		}
+		writeJSONWithNewline(c, 200, out)
+	}
+}
+
+// POST /blocklist
+// POST /allowlist
+// {"ip": "203.0.113.50", "ttl": "1h", "reason": "cheating"}
+//
+// ip is a single IP or a CIDR. ttl is optional; without it the entry
+// is permanent. Sessions the change shuts out are closed right away.
+// The first allowlist entry turns the allowlist on: from then on only
+// listed players get through. Since that shuts out every other player,
+// allowlist changes are refused with 403 for a caller with a player
+// restriction (see mayChangeAccess).
+func addAccess(relay *Relay, action string, add func(key string, expires uint64, reason string)) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		var req struct {
+			IP     string `json:"ip"`
+			TTL    string `json:"ttl"`
+			Reason string `json:"reason"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.String(400, "invalid json\n")
+			return
+		}
+		key, ok := canonicalAccessKey(req.IP)
+		if !ok {
+			c.String(400, "invalid ip\n")
+			return
+		}
+		if !mayChangeAccess(callerOf(c), action, key) {
+			c.String(403, "forbidden\n")
+			return
+		}
+		var expires uint64
+		if req.TTL != "" {
+			d, err := time.ParseDuration(req.TTL)
+			if err != nil || d <= 0 {
+				c.String(400, "invalid ttl\n")
+				return
+			}
+			expires = expiryAfter(d)
+		}
+		if req.Reason == "" {
+			req.Reason = "api"
+		}
+		add(key, expires, req.Reason)
+		a := auditFrom(c).of(action, key, "", "")
+		a.Detail = req.Reason
+		if req.TTL != "" {
+			a.Detail += " (ttl " + req.TTL + ")"
+		}
+		relay.audit.Record(a)
+		writeJSONWithNewline(c, 200, pulpgin.H{"status": "ok", "ip": key})
+	}
+}
+
+// DELETE /blocklist/:ip
+// DELETE /allowlist/:ip
+// DELETE /blocklist {"ips": ["203.0.113.50", "10.0.0.0/8"]}
+// DELETE /allowlist {"ips": [...]}
+//
+// Removes entries; a CIDR has to go in the body since it can't travel
+// as a path segment. Unknown entries are reported as "not_found", and
+// entries the caller may not change (see mayChangeAccess) as
+// "forbidden".
+func removeAccess(relay *Relay, action string, remove func(key string) bool) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		var ips []string
+		if ip := c.Param("ip"); ip != "" {
+			ips = []string{ip}
+		} else {
+			var req struct {
+				IPs []string `json:"ips"`
+			}
+			if err := c.BindJSON(&req); err != nil {
+				c.String(400, "invalid json\n")
+				return
+			}
+			ips = req.IPs
+		}
+		if len(ips) == 0 {
+			c.String(400, "ips required\n")
+			return
+		}
+		results := make([]pulpgin.H, len(ips))
+		cl := callerOf(c)
+		a := auditFrom(c)
+		for i, ip := range ips {
+			status := "not_found"
+			if key, ok := canonicalAccessKey(ip); ok && !mayChangeAccess(cl, action, key) {
+				status = "forbidden"
+			} else if ok && remove(key) {
+				status = "deleted"
+				relay.audit.Record(a.of(action, key, "", ""))
+			}
+			results[i] = pulpgin.H{"ip": ip, "status": status}
+		}
+		writeJSONWithNewline(c, 200, pulpgin.H{"status": "ok", "results": results})
+	}
+}
+
+// mayChangeAccess reports whether cl may add or remove the access-list
+// entry key for action. A blocklist entry only affects the players it
+// covers, so the caller's player restriction applies. The allowlist is
+// different: its first entry locks out every unlisted player and
+// removing its last lets them all back in, so like pools it is shared
+// state a restricted caller can't change.
+func mayChangeAccess(cl *caller, action, key string) bool {
+	switch action {
+	case auditAllowlistAdd, auditAllowlistRemove:
+		return cl.unrestricted()
+	}
+	return cl.mayMutate(key)
+}
+
+// GET /audit
+// GET /audit?since=<id>
+//
+// The control-API changes still in the audit ring, oldest first: who
+// made each one (caller and remote_addr), the request it belonged to,
+// and the backend before and after. since skips the entries up to and
+// including that ID, for polling.
+func listAudit(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		var since uint64
+		if q := c.Query("since"); q != "" {
+			n, err := strconv.ParseUint(q, 10, 64)
+			if err != nil {
+				c.String(400, "invalid since\n")
+				return
+			}
+			since = n
+		}
+		writeJSONWithNewline(c, 200, relay.audit.Since(since))
+	}
+}
+
+// GET /pools
+//
+// Every configured backend pool with its policy and weighted members.
+func listPools(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		writeJSONWithNewline(c, 200, relay.Pools())
+	}
+}
+
+// POST /pools
+// {"name": "lobby", "policy": "least_sessions",
+//
+//	"backends": [{"addr": "10.0.50.2:5521", "weight": 2}, ...]}
+//
+// Creates or replaces a pool. Routes target it with backend
+// "pool:lobby". policy defaults to "weighted_random" and weight to 1.
+// Live sessions keep the member they were given. Pools are shared by
+// every player, so a caller with a player restriction can't change
+// them.
+func setPool(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		if !callerOf(c).unrestricted() {
+			c.String(403, "forbidden\n")
+			return
+		}
+		var p backendPool
+		if err := c.BindJSON(&p); err != nil {
+			c.String(400, "invalid json\n")
+			return
+		}
+		if msg := p.normalize(relay.dest); msg != "" {
+			c.String(400, msg+"\n")
+			return
+		}
+		relay.SetPool(p)
+		log.Printf("Pool set: %s (%s, %d backends)", p.Name, p.Policy, len(p.Backends))
+		a := auditFrom(c).of(auditPoolSet, "", "", "")
+		a.Detail = fmt.Sprintf("%s (%s, %d backends)", p.Name, p.Policy, len(p.Backends))
+		relay.audit.Record(a)
+		writeJSONWithNewline(c, 200, pulpgin.H{"status": "ok"})
+	}
+}
+
+// DELETE /pools/:name
+//
+// 404 for an unknown pool. Refused with 409 while any route still
+// targets the pool, and with 403 for a caller with a player
+// restriction.
+func deletePool(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		if !callerOf(c).unrestricted() {
+			c.String(403, "forbidden\n")
+			return
+		}
+		name := c.Param("name")
+		switch err := relay.DeletePool(name); {
+		case errors.Is(err, errPoolNotFound):
+			c.String(404, err.Error()+"\n")
+			return
+		case err != nil:
+			c.String(409, err.Error()+"\n")
+			return
+		}
+		log.Printf("Pool deleted: %s", name)
+		a := auditFrom(c).of(auditPoolDeleted, "", "", "")
+		a.Detail = name
+		relay.audit.Record(a)
+		writeJSONWithNewline(c, 200, pulpgin.H{"status": "ok"})
+	}
+}
+
 // GET /health
 //
 // Native never explicitly sets Content-Type; Go's http.DetectContentType
@@ -140,8 +749,71 @@ func listRoutes(relay *Relay) pulpgin.HandlerFunc {
 // the sniff path in the cell without bypassing pulpgin, so we set the
 // type explicitly — harness header comparison strips charset and the
 // /health case ignores Content-Type anyway.
-func health(c *pulpgin.Context) {
-	writeJSONWithNewline(c, 200, pulpgin.H{"status": "healthy"})
+//
+// "bananasplit" reports the route-lookup circuit breaker. An open
+// breaker leaves the status "healthy": Peel keeps relaying every player
+// that already has a route, so it should not be restarted for it.
+func health(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		writeJSONWithNewline(c, 200, pulpgin.H{
+			"status":      "healthy",
+			"bananasplit": relay.breaker.Status(time.Now().UnixNano()),
+		})
+	}
+}
+
+// GET /metrics
+//
+// Prometheus text exposition of the relay's counters and gauges. Open
+// like the other GET observability routes.
+func metrics(relay *Relay, auth *tokenAuth) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		var b strings.Builder
+		relay.WritePrometheus(&b)
+		auth.WritePrometheus(&b)
+		c.Data(200, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+	}
+}
+
+// GET /events
+//
+// Replay of the route and session changes since an event ID, in
+// server-sent-events framing. This is NOT a real-time push:
+// Engine.Dispatch does carry WebSocket events, but Peel registers no
+// WebSocket handler yet, and an HTTP response can't be held open on
+// the step loop. Each call returns the buffered events newer than the
+// client's cursor and ends. The "retry" field makes an EventSource
+// poll again after a second, and its automatic Last-Event-ID header
+// resumes exactly where it left off. Other clients can pass
+// ?since=<id> instead. Events older than the event_buffer ring are
+// gone.
+func streamEvents(relay *Relay) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		cursor := c.GetHeader("Last-Event-ID")
+		if q := c.Query("since"); q != "" {
+			cursor = q
+		}
+		var since uint64
+		if cursor != "" {
+			n, err := strconv.ParseUint(cursor, 10, 64)
+			if err != nil {
+				c.String(400, "invalid event id\n")
+				return
+			}
+			since = n
+		}
+
+		var b strings.Builder
+		b.WriteString("retry: 1000\n\n")
+		for _, ev := range relay.events.Since(since) {
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&b, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+		}
+		c.Data(200, "text/event-stream", []byte(b.String()))
+	}
 }
 
 // writeJSONWithNewline mirrors the native stdlib pattern
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
+)
+
+// Audited control-API actions.
+const (
+	auditRouteSet        = "route.set"
+	auditRouteChanged    = "route.changed"
+	auditRouteDeleted    = "route.deleted"
+	auditSessionClosed   = "session.closed"
+	auditNegativePurged  = "negative_cache.purged"
+	auditBlocklistAdd    = "blocklist.added"
+	auditBlocklistRemove = "blocklist.removed"
+	auditAllowlistAdd    = "allowlist.added"
+	auditAllowlistRemove = "allowlist.removed"
+	auditPoolSet         = "pool.set"
+	auditPoolDeleted     = "pool.deleted"
+)
+
+// auditEntry records one change made through the control API: who made
+// it (the token or JWT subject, "anonymous" with auth off), from where,
+// and the backend before and after. Entries written by one request
+// share its RequestID.
+type auditEntry struct {
+	ID         uint64    `json:"id"`
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	Caller     string    `json:"caller"`
+	RemoteAddr string    `json:"remote_addr"`
+	Action     string    `json:"action"`
+	PlayerIP   string    `json:"player_ip,omitempty"`
+	OldBackend string    `json:"old_backend,omitempty"`
+	NewBackend string    `json:"new_backend,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+// of returns a copy of the request-level entry e for one action.
+func (e auditEntry) of(action, playerIP, oldBackend, newBackend string) auditEntry {
+	e.Action = action
+	e.PlayerIP = playerIP
+	e.OldBackend = oldBackend
+	e.NewBackend = newBackend
+	return e
+}
+
+// auditFrom starts the audit entry for the request on c. The request
+// ID is the caller's X-Request-ID when it sends a usable one, a random
+// one otherwise; either way it is echoed back in X-Request-ID so the
+// caller can find its changes in GET /audit.
+func auditFrom(c *pulpgin.Context) auditEntry {
+	id := c.GetHeader("X-Request-ID")
+	if id == "" || len(id) > 128 {
+		id = newRequestID()
+	}
+	c.Header("X-Request-ID", id)
+	e := auditEntry{RequestID: id, Caller: "anonymous", RemoteAddr: c.ClientIP()}
+	if cl := callerOf(c); cl != nil {
+		e.Caller = cl.Via + ":" + cl.Name
+	}
+	return e
+}
+
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// auditLog keeps the most recent audit entries in a fixed-size ring,
+// like eventBus, and appends each one as a JSON line to path when set.
+// The file is opened per entry — control-API writes are rare — so an
+// external logrotate can move it at any time.
+type auditLog struct {
+	ring   []auditEntry
+	next   int    // ring index of the next write
+	lastID uint64 // ID of the newest entry; 0 before the first
+	path   string
+
+	fileErrors uint64
+}
+
+func newAuditLog(size int, path string) *auditLog {
+	return &auditLog{ring: make([]auditEntry, 0, size), path: path}
+}
+
+// Record stamps e with the next ID and the current time, stores it and
+// appends it to the audit file. A file error is logged and counted; it
+// never fails the API call.
+func (l *auditLog) Record(e auditEntry) {
+	l.lastID++
+	e.ID = l.lastID
+	e.Time = time.Now().UTC()
+	if cap(l.ring) > 0 {
+		if len(l.ring) < cap(l.ring) {
+			l.ring = append(l.ring, e)
+		} else {
+			l.ring[l.next] = e
+		}
+		l.next = (l.next + 1) % cap(l.ring)
+	}
+	if l.path == "" {
+		return
+	}
+	if err := l.append(e); err != nil {
+		l.fileErrors++
+		log.Printf("Audit file write failed: %v", err)
+	}
+}
+
+func (l *auditLog) append(e auditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Since returns the buffered entries with ID greater than id, oldest
+// first.
+func (l *auditLog) Since(id uint64) []auditEntry {
+	out := []auditEntry{}
+	n := len(l.ring)
+	start := 0
+	if n == cap(l.ring) {
+		start = l.next
+	}
+	for i := 0; i < n; i++ {
+		if e := l.ring[(start+i)%n]; e.ID > id {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+	"time"
+
+	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
+)
+
+// Token scopes. route-write and session-admin each imply read-only.
+//
+//   - "read-only": the GET observability routes (only enforced when
+//     auth_reads is on).
+//   - "route-write": routes, pools and the negative cache.
+//   - "session-admin": closing sessions and the blocklist/allowlist.
+const (
+	scopeRead         = "read-only"
+	scopeRouteWrite   = "route-write"
+	scopeSessionAdmin = "session-admin"
+)
+
+// callerKey is the context key under which an authenticated request's
+// *caller is stored for handlers.
+const callerKey = "peel.caller"
+
+func validScope(s string) bool {
+	return s == scopeRead || s == scopeRouteWrite || s == scopeSessionAdmin
+}
+
+// serviceToken is one named control-API credential.
+type serviceToken struct {
+	Name    string
+	Secret  string
+	Scopes  []string
+	Expires time.Time // zero: never
+}
+
+// caller is who made an authenticated control-API request: a named
+// service token or the subject of a JWT.
+type caller struct {
+	Name    string
+	Via     string // "token" or "jwt"
+	Scopes  []string
+	Players []netip.Prefix // player IPs it may mutate; nil: any
+}
+
+// allows reports whether cl grants scope.
+func (cl *caller) allows(scope string) bool {
+	for _, s := range cl.Scopes {
+		if s == scope || scope == scopeRead {
+			return true
+		}
+	}
+	return false
+}
+
+// mayMutate reports whether cl may change state for the player key (an
+// IP, flow or CIDR). A restricted caller may touch a CIDR only when one
+// of its prefixes covers all of it, and never the default route. A nil
+// caller (auth off) may touch anything.
+func (cl *caller) mayMutate(key string) bool {
+	if cl == nil || cl.Players == nil {
+		return true
+	}
+	if routeKind(key) == routePrefix {
+		p, err := netip.ParsePrefix(key)
+		if err != nil {
+			return false
+		}
+		p = p.Masked()
+		for _, q := range cl.Players {
+			if q.Bits() <= p.Bits() && q.Contains(p.Addr()) {
+				return true
+			}
+		}
+		return false
+	}
+	addr, ok := parsePlayerAddr(key)
+	if !ok {
+		return false
+	}
+	for _, q := range cl.Players {
+		if q.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// unrestricted reports whether cl may change state that isn't tied to
+// particular players, such as pools or the whole negative cache.
+func (cl *caller) unrestricted() bool {
+	return cl == nil || cl.Players == nil
+}
+
+// callerOf returns the caller require stored on c, or nil when auth is
+// off.
+func callerOf(c *pulpgin.Context) *caller {
+	v, _ := c.Get(callerKey)
+	cl, _ := v.(*caller)
+	return cl
+}
+
+// tokenAuth checks X-Service-Token against a set of named, scoped
+// tokens. Several tokens may be valid at once, which is what makes
+// rotation safe: add the new token, move callers over at their own pace,
+// and let the old one run out at its expiry — no lockstep deploy.
+//
+// When JWT auth is configured, an "Authorization: Bearer" JWT is
+// accepted as well; its scope and peel_players claims give each caller
+// least-privilege access (see jwtVerifier).
+//
+// With neither configured auth is off and every call passes, keeping
+// the auth-available-not-mandatory posture of the single SERVICE_TOKEN
+// it replaces.
+type tokenAuth struct {
+	tokens    []*serviceToken
+	jwt       *jwtVerifier
+	authReads bool
+
+	uses     map[string]uint64 // by token name
+	jwtUses  map[string]uint64 // by JWT subject
+	rejected map[string]uint64 // by reason: missing, invalid, expired, scope
+}
+
+func newTokenAuth(cfg appConfig, verifier *jwtVerifier) *tokenAuth {
+	a := &tokenAuth{
+		jwt:       verifier,
+		authReads: cfg.AuthReads,
+		uses:      make(map[string]uint64),
+		jwtUses:   make(map[string]uint64),
+		rejected:  make(map[string]uint64),
+	}
+	for i := range cfg.ServiceTokens {
+		a.tokens = append(a.tokens, &cfg.ServiceTokens[i])
+	}
+	return a
+}
+
+// enabled reports whether any token or JWT key is configured.
+func (a *tokenAuth) enabled() bool {
+	return len(a.tokens) > 0 || a.jwt != nil
+}
+
+// authenticate finds the token matching secret at now. Every token is
+// compared in constant time so the response time doesn't leak which
+// one nearly matched.
+func (a *tokenAuth) authenticate(secret string, now time.Time) (*caller, string) {
+	if secret == "" {
+		return nil, "missing"
+	}
+	var found *serviceToken
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(t.Secret)) == 1 {
+			found = t
+		}
+	}
+	if found == nil {
+		return nil, "invalid"
+	}
+	if !found.Expires.IsZero() && !now.Before(found.Expires) {
+		return nil, "expired"
+	}
+	return &caller{Name: found.Name, Via: "token", Scopes: found.Scopes}, ""
+}
+
+// identify authenticates the request on c: a bearer JWT when JWT auth
+// is configured and one is sent, the X-Service-Token otherwise.
+func (a *tokenAuth) identify(c *pulpgin.Context, call string) (*caller, string) {
+	if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && a.jwt != nil {
+		cl, reason, err := a.jwt.Verify(strings.TrimSpace(bearer))
+		if err != nil {
+			log.Printf("Control API %s: JWT rejected: %v", call, err)
+		}
+		return cl, reason
+	}
+	return a.authenticate(c.GetHeader("X-Service-Token"), time.Now())
+}
+
+// require wraps next so it only runs for a token granting scope. call
+// names the endpoint ("POST /routes") in the log line every mutating
+// call gets. Failures answer 401 (no, unknown or expired token) or 403
+// (valid token without the scope) in native Peel's plain-text error
+// shape. Handlers apply the caller's player restriction themselves, via
+// callerOf.
+func (a *tokenAuth) require(scope, call string, next pulpgin.HandlerFunc) pulpgin.HandlerFunc {
+	return func(c *pulpgin.Context) {
+		if !a.enabled() || (scope == scopeRead && !a.authReads) {
+			next(c)
+			return
+		}
+		cl, reason := a.identify(c, call)
+		if cl == nil {
+			a.rejected[reason]++
+			c.String(401, "unauthorized\n")
+			return
+		}
+		if !cl.allows(scope) {
+			a.rejected["scope"]++
+			log.Printf("Control API %s denied: %s %s lacks %s", call, cl.Via, cl.Name, scope)
+			c.String(403, "forbidden\n")
+			return
+		}
+		if cl.Via == "jwt" {
+			a.jwtUses[cl.Name]++
+		} else {
+			a.uses[cl.Name]++
+		}
+		c.Set(callerKey, cl)
+		if scope != scopeRead {
+			log.Printf("Control API %s by %s %s", call, cl.Via, cl.Name)
+		}
+		next(c)
+	}
+}
+
+// read is require for the GET observability routes.
+func (a *tokenAuth) read(next pulpgin.HandlerFunc) pulpgin.HandlerFunc {
+	return a.require(scopeRead, "GET", next)
+}
+
+// WritePrometheus appends the control-API auth metrics. Per-token use
+// counts show when callers have moved off a token being rotated out.
+func (a *tokenAuth) WritePrometheus(b *strings.Builder) {
+	writeHelp(b, "peel_auth_token_uses_total", "counter", "Authenticated control-API calls, by token name.")
+	writeLabeled(b, "peel_auth_token_uses_total", "token", a.uses)
+	writeHelp(b, "peel_auth_jwt_uses_total", "counter", "Control-API calls authenticated by JWT, by subject.")
+	writeLabeled(b, "peel_auth_jwt_uses_total", "subject", a.jwtUses)
+	writeHelp(b, "peel_auth_rejected_total", "counter", "Control-API calls refused, by reason.")
+	writeLabeled(b, "peel_auth_rejected_total", "reason", a.rejected)
+}
+
+// parseServiceToken validates one service_tokens entry. The secret
+// comes from token, or from the environment variable token_env so it
+// can stay out of the committed manifest.
+func parseServiceToken(name, token, tokenEnv string, scopes []string, expires string, lookupEnv func(string) string) (serviceToken, error) {
+	t := serviceToken{Name: name, Secret: token, Scopes: scopes}
+	if name == "" {
+		return t, fmt.Errorf("service token without name")
+	}
+	if tokenEnv != "" {
+		t.Secret = lookupEnv(tokenEnv)
+	}
+	if t.Secret == "" {
+		return t, fmt.Errorf("service token %q: empty token", name)
+	}
+	if len(scopes) == 0 {
+		return t, fmt.Errorf("service token %q: no scopes", name)
+	}
+	for _, s := range scopes {
+		if !validScope(s) {
+			return t, fmt.Errorf("service token %q: invalid scope %q", name, s)
+		}
+	}
+	if expires != "" {
+		exp, err := time.Parse(time.RFC3339, expires)
+		if err != nil {
+			return t, fmt.Errorf("service token %q: invalid expires %q", name, expires)
+		}
+		t.Expires = exp
+	}
+	return t, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Circuit breaker states.
+//
+//   - "closed": lookups go to Bananasplit; consecutive failures are
+//     counted.
+//   - "open": after threshold consecutive failures every lookup fails
+//     immediately, without a Fetch, for cooldown.
+//   - "half_open": after the cooldown a single probe lookup is let
+//     through; success closes the breaker, failure re-opens it. Other
+//     lookups keep failing fast while the probe is in flight.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// circuitBreaker guards requestRoute so a Bananasplit outage costs one
+// fast failure per new IP instead of a 5s Fetch each.
+type circuitBreaker struct {
+	threshold int // 0 disables the breaker
+	cooldown  time.Duration
+
+	state    string
+	failures int
+	retryAt  int64 // wall-time nanoseconds; open until then
+	probing  bool
+	trips    uint64
+	rejected uint64
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, state: breakerClosed}
+}
+
+// Allow reports whether a lookup may go out at now, and whether it is
+// the half-open probe. In half-open state it admits exactly one probe
+// at a time.
+func (b *circuitBreaker) Allow(now int64) (ok, probe bool) {
+	switch b.state {
+	case breakerOpen:
+		if now < b.retryAt {
+			b.rejected++
+			return false, false
+		}
+		b.state = breakerHalfOpen
+		log.Printf("Bananasplit circuit half-open, probing")
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			b.rejected++
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	}
+	return true, false
+}
+
+// Record applies the outcome of an admitted lookup; probe says whether
+// it was the half-open probe. Only failures that say Bananasplit is
+// unreachable or broken (transport errors, 5xx) count; any other answer
+// proves it is up.
+func (b *circuitBreaker) Record(probe, failed bool, now int64) {
+	if b.threshold <= 0 {
+		return
+	}
+	if probe {
+		b.probing = false
+	}
+	if !failed {
+		if b.state != breakerClosed {
+			log.Printf("Bananasplit circuit closed")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+	b.failures++
+	if probe || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.retryAt = now + int64(b.cooldown)
+		b.trips++
+		log.Printf("Bananasplit circuit open after %d consecutive failures; retrying in %s", b.failures, b.cooldown)
+	}
+}
+
+// breakerStatus is the breaker section of GET /health.
+type breakerStatus struct {
+	State    string `json:"state"`
+	Failures int    `json:"consecutive_failures"`
+	RetryIn  *int64 `json:"retry_in,omitempty"` // seconds, while open
+}
+
+// Status returns the breaker state at now.
+func (b *circuitBreaker) Status(now int64) breakerStatus {
+	s := breakerStatus{State: b.state, Failures: b.failures}
+	if b.state == breakerOpen {
+		secs := max(b.retryAt-now, 0) / int64(time.Second)
+		s.RetryIn = &secs
+	}
+	return s
+}
@@ -9,12 +9,132 @@ import (
 
 // appConfig is the msgpack-decoded [config] table from pulp.cell.toml.
 type appConfig struct {
-	ListenAddr     string
-	APIAddr        string
-	BananasplitURL string
-	BufferSize     int
-	IdleTimeout    time.Duration
-	ServiceToken   string
+	ListenAddr  string
+	APIAddr     string
+	BufferSize  int
+	IdleTimeout time.Duration
+
+	// BananasplitURLs are the Bananasplit replicas, tried per
+	// BananasplitPolicy ("failover" or "round_robin"). BananasplitURL
+	// is the first of them, as native's single URL.
+	BananasplitURL    string
+	BananasplitURLs   []string
+	BananasplitPolicy string
+	ServiceToken      string
+
+	// ServiceTokens are the named, scoped control-API tokens; a
+	// SERVICE_TOKEN is folded in as "default" with every scope. AuthReads
+	// also requires a read-only token on the GET routes (except /health).
+	ServiceTokens []serviceToken
+	AuthReads     bool
+
+	// JWT auth: bearer tokens signed with JWTKey (HS256) or a key from
+	// the static JWKSFile, issued by JWTIssuer for JWTAudience. Off
+	// when neither key source is set.
+	JWTKey      string
+	JWKSFile    string
+	JWTIssuer   string
+	JWTAudience string
+
+	// HotSwap keeps a session's outbound socket across a backend change
+	// instead of closing it; SwapGrace is how long replies from the old
+	// backend are still relayed afterwards.
+	HotSwap   bool
+	SwapGrace time.Duration
+
+	// PendingQueue caps how many packets per IP are held while that
+	// IP's route lookup is in flight; PendingTimeout is how long they
+	// are held before being dropped. PendingTimeout defaults to long
+	// enough for a lookup that fails over across every Bananasplit URL.
+	PendingQueue   int
+	PendingTimeout time.Duration
+
+	// NegativeCacheTTL is how long an IP whose route lookup failed is
+	// dropped without another lookup; each further consecutive failure
+	// doubles it, up to NegativeCacheMax.
+	NegativeCacheTTL time.Duration
+	NegativeCacheMax time.Duration
+
+	// BreakerThreshold consecutive failed Bananasplit lookups open the
+	// circuit breaker for BreakerCooldown; zero disables it.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Inbound flood protection. RateLimitPPS/RateLimitBurst bound the
+	// packets per second from each source IP (0 disables);
+	// LookupRate/LookupBurst bound new-IP Bananasplit lookups per
+	// second across all IPs (0 disables). An IP with FloodBlockAfter
+	// rate-limited packets within 10s is dropped entirely for
+	// FloodBlockFor (0 never blocks).
+	RateLimitPPS    float64
+	RateLimitBurst  int
+	LookupRate      float64
+	LookupBurst     int
+	FloodBlockAfter int
+	FloodBlockFor   time.Duration
+
+	// RouteTTL is how long a route learned from Bananasplit lives
+	// before the sweep evicts it. Zero keeps routes forever.
+	RouteTTL time.Duration
+
+	// ProxyProtocol is the default PROXY protocol v2 mode toward
+	// backends: "off", "header" or "preamble".
+	ProxyProtocol string
+
+	// StateFile is where routes are snapshotted every SnapshotInterval
+	// and on shutdown; empty disables persistence. Snapshots older than
+	// SnapshotMaxAge are ignored at boot.
+	StateFile        string
+	SnapshotInterval time.Duration
+	SnapshotMaxAge   time.Duration
+
+	// SyncInterval is how often the full route set is reconciled
+	// against Bananasplit (first run right after boot). Zero disables.
+	SyncInterval time.Duration
+
+	// Watch enables the route watch against Bananasplit; WatchHold is
+	// how long each poll asks Bananasplit to hold open, at most
+	// maxWatchHold.
+	Watch     bool
+	WatchHold time.Duration
+
+	// EventBuffer is how many recent route/session events GET /events
+	// can replay.
+	EventBuffer int
+
+	// AuditBuffer is how many recent control-API changes GET /audit
+	// can return; AuditFile, when set, also gets each one appended as
+	// a JSON line.
+	AuditBuffer int
+	AuditFile   string
+
+	// Webhook delivery of session lifecycle events. Disabled when
+	// WebhookURL is empty.
+	WebhookURL     string
+	WebhookEvents  []string
+	WebhookBatch   int
+	WebhookFlush   time.Duration
+	WebhookOutbox  int
+	WebhookRetries int
+
+	// Backend health checking ("off", "udp", "http") and the optional
+	// fallback backend sessions fail over to.
+	HealthCheck     string
+	HealthInterval  time.Duration
+	HealthTimeout   time.Duration
+	HealthThreshold int
+	HealthPayload   string
+	HealthURL       string
+	FallbackBackend string
+
+	// Pools are named weighted backend sets routes can target as
+	// "pool:<name>".
+	Pools []backendPool
+
+	// Dest is the backend destination policy built from backend_cidrs
+	// and backend_ports; every route target, pool member and fallback
+	// must pass it.
+	Dest *destPolicy
 }
 
 func parseConfig(data []byte) (appConfig, error) {
@@ -30,12 +150,78 @@ func parseConfig(data []byte) (appConfig, error) {
 	jbytes, _ := json.Marshal(raw)
 
 	var tmp struct {
-		ListenAddr     string `json:"listen_addr"`
-		APIAddr        string `json:"api_addr"`
-		BananasplitURL string `json:"bananasplit_url"`
-		BufferSize     int    `json:"buffer_size"`
-		IdleTimeout    string `json:"idle_timeout"`
-		ServiceToken   string `json:"service_token"`
+		ListenAddr        string          `json:"listen_addr"`
+		APIAddr           string          `json:"api_addr"`
+		BananasplitURL    json.RawMessage `json:"bananasplit_url"`
+		BananasplitPolicy string          `json:"bananasplit_policy"`
+		BufferSize        int             `json:"buffer_size"`
+		IdleTimeout       string          `json:"idle_timeout"`
+		ServiceToken      string          `json:"service_token"`
+		HotSwap           bool            `json:"hot_swap"`
+		SwapGrace         string          `json:"swap_grace"`
+		PendingQueue      int             `json:"pending_queue"`
+		PendingTimeout    string          `json:"pending_timeout"`
+		RouteTTL          string          `json:"route_ttl"`
+		ProxyProtocol     string          `json:"proxy_protocol"`
+
+		NegativeCacheTTL string `json:"negative_cache_ttl"`
+		NegativeCacheMax string `json:"negative_cache_max"`
+		BreakerThreshold *int   `json:"breaker_threshold"`
+		BreakerCooldown  string `json:"breaker_cooldown"`
+
+		RateLimitPPS    float64 `json:"rate_limit_pps"`
+		RateLimitBurst  int     `json:"rate_limit_burst"`
+		LookupRate      float64 `json:"lookup_rate"`
+		LookupBurst     int     `json:"lookup_burst"`
+		FloodBlockAfter int     `json:"flood_block_after"`
+		FloodBlockFor   string  `json:"flood_block_duration"`
+
+		StateFile        string `json:"state_file"`
+		SnapshotInterval string `json:"snapshot_interval"`
+		SnapshotMaxAge   string `json:"snapshot_max_age"`
+		SyncInterval     string `json:"sync_interval"`
+		Watch            bool   `json:"watch"`
+		WatchHold        string `json:"watch_hold"`
+		EventBuffer      int    `json:"event_buffer"`
+		AuditBuffer      int    `json:"audit_buffer"`
+		AuditFile        string `json:"audit_file"`
+
+		WebhookURL     string   `json:"webhook_url"`
+		WebhookEvents  []string `json:"webhook_events"`
+		WebhookBatch   *int     `json:"webhook_batch"`
+		WebhookFlush   string   `json:"webhook_flush"`
+		WebhookOutbox  *int     `json:"webhook_outbox"`
+		WebhookRetries *int     `json:"webhook_retries"`
+
+		HealthCheck     string `json:"health_check"`
+		HealthInterval  string `json:"health_interval"`
+		HealthTimeout   string `json:"health_timeout"`
+		HealthThreshold *int   `json:"health_threshold"`
+		HealthPayload   string `json:"health_payload"`
+		HealthURL       string `json:"health_url"`
+		FallbackBackend string `json:"fallback_backend"`
+
+		BackendCIDRs []string `json:"backend_cidrs"`
+		BackendPorts []string `json:"backend_ports"`
+
+		Pools map[string]struct {
+			Policy   string       `json:"policy"`
+			Backends []poolMember `json:"backends"`
+		} `json:"pools"`
+
+		ServiceTokens []struct {
+			Name     string   `json:"name"`
+			Token    string   `json:"token"`
+			TokenEnv string   `json:"token_env"`
+			Scopes   []string `json:"scopes"`
+			Expires  string   `json:"expires"`
+		} `json:"service_tokens"`
+		AuthReads bool `json:"auth_reads"`
+
+		JWTKey      string `json:"jwt_hs256_key"`
+		JWKSFile    string `json:"jwt_jwks_file"`
+		JWTIssuer   string `json:"jwt_issuer"`
+		JWTAudience string `json:"jwt_audience"`
 	}
 	if err := json.Unmarshal(jbytes, &tmp); err != nil {
 		return cfg, fmt.Errorf("decode config: %w", err)
@@ -56,25 +242,241 @@ func parseConfig(data []byte) (appConfig, error) {
 	if cfg.APIAddr == "" {
 		cfg.APIAddr = ":8080"
 	}
-	cfg.BananasplitURL = tmp.BananasplitURL
-	if cfg.BananasplitURL == "" {
-		cfg.BananasplitURL = "http://localhost:3001"
+	// bananasplit_url is one URL or a list of replica URLs.
+	if len(tmp.BananasplitURL) > 0 {
+		var one string
+		if json.Unmarshal(tmp.BananasplitURL, &one) == nil {
+			if one != "" {
+				cfg.BananasplitURLs = []string{one}
+			}
+		} else if err := json.Unmarshal(tmp.BananasplitURL, &cfg.BananasplitURLs); err != nil {
+			return cfg, fmt.Errorf("invalid bananasplit_url: want a URL or a list of URLs")
+		}
+	}
+	if len(cfg.BananasplitURLs) == 0 {
+		cfg.BananasplitURLs = []string{"http://localhost:3001"}
+	}
+	cfg.BananasplitURL = cfg.BananasplitURLs[0]
+	cfg.BananasplitPolicy = tmp.BananasplitPolicy
+	if cfg.BananasplitPolicy == "" {
+		cfg.BananasplitPolicy = endpointFailover
+	}
+	if !validEndpointPolicy(cfg.BananasplitPolicy) {
+		return cfg, fmt.Errorf("invalid bananasplit_policy %q", cfg.BananasplitPolicy)
 	}
 	cfg.BufferSize = tmp.BufferSize
 	if cfg.BufferSize == 0 {
 		cfg.BufferSize = 8 * 1024 * 1024
 	}
 
-	idle := tmp.IdleTimeout
-	if idle == "" {
-		idle = "10m"
-	}
-	d, err := time.ParseDuration(idle)
+	d, err := parseDuration("idle_timeout", tmp.IdleTimeout, "10m")
 	if err != nil {
-		return cfg, fmt.Errorf("invalid idle_timeout %q: %w", idle, err)
+		return cfg, err
 	}
 	cfg.IdleTimeout = d
 
+	cfg.HotSwap = tmp.HotSwap
+	if cfg.SwapGrace, err = parseDuration("swap_grace", tmp.SwapGrace, "5s"); err != nil {
+		return cfg, err
+	}
+
+	cfg.PendingQueue = tmp.PendingQueue
+	if cfg.PendingQueue < 0 {
+		return cfg, fmt.Errorf("invalid pending_queue %d", cfg.PendingQueue)
+	}
+	if cfg.PendingQueue == 0 {
+		cfg.PendingQueue = 32
+	}
+	// A lookup may try each Bananasplit URL in turn, each for up to
+	// routeRequestTimeout; a shorter pending_timeout would drop the
+	// queue while the lookup is still failing over.
+	minPending := time.Duration(len(cfg.BananasplitURLs)) * routeRequestTimeout
+	if cfg.PendingTimeout, err = parseDuration("pending_timeout", tmp.PendingTimeout, (minPending + time.Second).String()); err != nil {
+		return cfg, err
+	}
+	if cfg.PendingTimeout < minPending {
+		return cfg, fmt.Errorf("pending_timeout %s is below %s, the time a lookup may take across %d Bananasplit URLs", cfg.PendingTimeout, minPending, len(cfg.BananasplitURLs))
+	}
+	if cfg.NegativeCacheTTL, err = parseDuration("negative_cache_ttl", tmp.NegativeCacheTTL, "30s"); err != nil {
+		return cfg, err
+	}
+	if cfg.NegativeCacheMax, err = parseDuration("negative_cache_max", tmp.NegativeCacheMax, "10m"); err != nil {
+		return cfg, err
+	}
+	if cfg.NegativeCacheTTL <= 0 {
+		return cfg, fmt.Errorf("invalid negative_cache_ttl %q", tmp.NegativeCacheTTL)
+	}
+	if cfg.NegativeCacheMax < cfg.NegativeCacheTTL {
+		return cfg, fmt.Errorf("negative_cache_max %s is below negative_cache_ttl %s", cfg.NegativeCacheMax, cfg.NegativeCacheTTL)
+	}
+	// Unlike the other counts, 0 is meaningful here (disabled), so only
+	// an absent key gets the default.
+	cfg.BreakerThreshold = 5
+	if tmp.BreakerThreshold != nil {
+		cfg.BreakerThreshold = *tmp.BreakerThreshold
+	}
+	if cfg.BreakerCooldown, err = parseDuration("breaker_cooldown", tmp.BreakerCooldown, "30s"); err != nil {
+		return cfg, err
+	}
+
+	// Bursts default to twice the rate (at least one packet).
+	cfg.RateLimitPPS = tmp.RateLimitPPS
+	cfg.RateLimitBurst = tmp.RateLimitBurst
+	if cfg.RateLimitBurst == 0 {
+		cfg.RateLimitBurst = max(int(2*cfg.RateLimitPPS), 1)
+	}
+	cfg.LookupRate = tmp.LookupRate
+	cfg.LookupBurst = tmp.LookupBurst
+	if cfg.LookupBurst == 0 {
+		cfg.LookupBurst = max(int(2*cfg.LookupRate), 1)
+	}
+	if cfg.RateLimitPPS < 0 || cfg.LookupRate < 0 || cfg.RateLimitBurst < 0 || cfg.LookupBurst < 0 {
+		return cfg, fmt.Errorf("rate limits must not be negative")
+	}
+	cfg.FloodBlockAfter = tmp.FloodBlockAfter
+	if cfg.FloodBlockFor, err = parseDuration("flood_block_duration", tmp.FloodBlockFor, "1m"); err != nil {
+		return cfg, err
+	}
+	if cfg.RouteTTL, err = parseDuration("route_ttl", tmp.RouteTTL, "0s"); err != nil {
+		return cfg, err
+	}
+
+	cfg.ProxyProtocol = tmp.ProxyProtocol
+	if cfg.ProxyProtocol == "" {
+		cfg.ProxyProtocol = proxyOff
+	}
+	if !validProxyMode(cfg.ProxyProtocol) {
+		return cfg, fmt.Errorf("invalid proxy_protocol %q", cfg.ProxyProtocol)
+	}
+
+	cfg.StateFile = tmp.StateFile
+	if cfg.SnapshotInterval, err = parseDuration("snapshot_interval", tmp.SnapshotInterval, "30s"); err != nil {
+		return cfg, err
+	}
+	if cfg.SnapshotMaxAge, err = parseDuration("snapshot_max_age", tmp.SnapshotMaxAge, "15m"); err != nil {
+		return cfg, err
+	}
+	if cfg.SyncInterval, err = parseDuration("sync_interval", tmp.SyncInterval, "0s"); err != nil {
+		return cfg, err
+	}
+	cfg.Watch = tmp.Watch
+	if cfg.WatchHold, err = parseDuration("watch_hold", tmp.WatchHold, "0s"); err != nil {
+		return cfg, err
+	}
+	if cfg.WatchHold < 0 || cfg.WatchHold > maxWatchHold {
+		return cfg, fmt.Errorf("invalid watch_hold %s: must be between 0s and %s, since each poll blocks relaying while it is held", cfg.WatchHold, maxWatchHold)
+	}
+
+	cfg.EventBuffer = tmp.EventBuffer
+	if cfg.EventBuffer == 0 {
+		cfg.EventBuffer = 1024
+	}
+	if cfg.EventBuffer < 0 {
+		return cfg, fmt.Errorf("invalid event_buffer %d", cfg.EventBuffer)
+	}
+	cfg.AuditBuffer = tmp.AuditBuffer
+	if cfg.AuditBuffer == 0 {
+		cfg.AuditBuffer = 1024
+	}
+	if cfg.AuditBuffer < 0 {
+		return cfg, fmt.Errorf("invalid audit_buffer %d", cfg.AuditBuffer)
+	}
+	cfg.AuditFile = tmp.AuditFile
+
+	cfg.WebhookURL = tmp.WebhookURL
+	cfg.WebhookEvents = tmp.WebhookEvents
+	if len(cfg.WebhookEvents) == 0 {
+		cfg.WebhookEvents = []string{evSessionCreated, evSessionClosed, evSessionBackend}
+	}
+	cfg.WebhookBatch = 50
+	if tmp.WebhookBatch != nil {
+		cfg.WebhookBatch = *tmp.WebhookBatch
+	}
+	if cfg.WebhookBatch < 1 {
+		return cfg, fmt.Errorf("invalid webhook_batch %d", cfg.WebhookBatch)
+	}
+	if cfg.WebhookFlush, err = parseDuration("webhook_flush", tmp.WebhookFlush, "1s"); err != nil {
+		return cfg, err
+	}
+	// The outbox must hold at least one event; zero retries is valid
+	// and drops a batch after its first failed attempt.
+	cfg.WebhookOutbox = 10000
+	if tmp.WebhookOutbox != nil {
+		cfg.WebhookOutbox = *tmp.WebhookOutbox
+	}
+	if cfg.WebhookOutbox < 1 {
+		return cfg, fmt.Errorf("invalid webhook_outbox %d", cfg.WebhookOutbox)
+	}
+	cfg.WebhookRetries = 5
+	if tmp.WebhookRetries != nil {
+		cfg.WebhookRetries = *tmp.WebhookRetries
+	}
+	if cfg.WebhookRetries < 0 {
+		return cfg, fmt.Errorf("invalid webhook_retries %d", cfg.WebhookRetries)
+	}
+
+	cfg.HealthCheck = tmp.HealthCheck
+	switch cfg.HealthCheck {
+	case "":
+		cfg.HealthCheck = healthOff
+	case healthOff, healthUDP:
+	case healthHTTP:
+		if tmp.HealthURL == "" {
+			return cfg, fmt.Errorf("health_check \"http\" requires health_url")
+		}
+	default:
+		return cfg, fmt.Errorf("invalid health_check %q", cfg.HealthCheck)
+	}
+	if cfg.HealthInterval, err = parseDuration("health_interval", tmp.HealthInterval, "10s"); err != nil {
+		return cfg, err
+	}
+	if cfg.HealthInterval <= 0 {
+		return cfg, fmt.Errorf("invalid health_interval %s", cfg.HealthInterval)
+	}
+	// An HTTP probe blocks relaying until it answers or times out, so its
+	// timeout is held to maxHTTPHealthTimeout; a UDP probe doesn't block.
+	defTimeout := "2s"
+	if cfg.HealthCheck == healthHTTP {
+		defTimeout = maxHTTPHealthTimeout.String()
+	}
+	if cfg.HealthTimeout, err = parseDuration("health_timeout", tmp.HealthTimeout, defTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.HealthTimeout <= 0 {
+		return cfg, fmt.Errorf("invalid health_timeout %s", cfg.HealthTimeout)
+	}
+	if cfg.HealthCheck == healthHTTP && cfg.HealthTimeout > maxHTTPHealthTimeout {
+		return cfg, fmt.Errorf("invalid health_timeout %s: HTTP probes may wait at most %s, since each one blocks relaying while it waits", cfg.HealthTimeout, maxHTTPHealthTimeout)
+	}
+	cfg.HealthThreshold = 3
+	if tmp.HealthThreshold != nil {
+		cfg.HealthThreshold = *tmp.HealthThreshold
+	}
+	if cfg.HealthThreshold < 1 {
+		return cfg, fmt.Errorf("invalid health_threshold %d", cfg.HealthThreshold)
+	}
+	cfg.HealthPayload = tmp.HealthPayload
+	if cfg.HealthPayload == "" {
+		cfg.HealthPayload = "ping"
+	}
+	cfg.HealthURL = tmp.HealthURL
+	if cfg.Dest, err = newDestPolicy(cfg.ListenAddr, tmp.BackendCIDRs, tmp.BackendPorts); err != nil {
+		return cfg, err
+	}
+	cfg.FallbackBackend = tmp.FallbackBackend
+	if cfg.FallbackBackend != "" {
+		if err := cfg.Dest.Check(cfg.FallbackBackend); err != nil {
+			return cfg, fmt.Errorf("invalid fallback_backend %q: %w", cfg.FallbackBackend, err)
+		}
+	}
+	for name, p := range tmp.Pools {
+		pool := backendPool{Name: name, Policy: p.Policy, Backends: p.Backends}
+		if msg := pool.normalize(cfg.Dest); msg != "" {
+			return cfg, fmt.Errorf("pool %q: %s", name, msg)
+		}
+		cfg.Pools = append(cfg.Pools, pool)
+	}
+
 	// SERVICE_TOKEN env (set by the Pulp host) wins over the manifest so
 	// secrets stay out of the committed pulp.cell.toml. The mutating
 	// control API is gated on this token ONLY when it's non-empty; an empty
@@ -84,6 +486,62 @@ func parseConfig(data []byte) (appConfig, error) {
 	if st := os.Getenv("SERVICE_TOKEN"); st != "" {
 		cfg.ServiceToken = st
 	}
+	if cfg.ServiceToken != "" {
+		cfg.ServiceTokens = append(cfg.ServiceTokens, serviceToken{
+			Name:   "default",
+			Secret: cfg.ServiceToken,
+			Scopes: []string{scopeRead, scopeRouteWrite, scopeSessionAdmin},
+		})
+	}
+	// Named tokens. Two entries with different names may be live at once
+	// so a secret can be rotated without a lockstep deploy; give the old
+	// one an expires and it stops being accepted on its own.
+	for _, st := range tmp.ServiceTokens {
+		tok, err := parseServiceToken(st.Name, st.Token, st.TokenEnv, st.Scopes, st.Expires, os.Getenv)
+		if err != nil {
+			return cfg, err
+		}
+		for _, other := range cfg.ServiceTokens {
+			if other.Name == tok.Name {
+				return cfg, fmt.Errorf("duplicate service token name %q", tok.Name)
+			}
+			if other.Secret == tok.Secret {
+				return cfg, fmt.Errorf("service token %q reuses the secret of %q", tok.Name, other.Name)
+			}
+		}
+		cfg.ServiceTokens = append(cfg.ServiceTokens, tok)
+	}
+	cfg.AuthReads = tmp.AuthReads
+
+	// JWT_HS256_KEY env wins over the manifest, like SERVICE_TOKEN.
+	cfg.JWTKey = tmp.JWTKey
+	if k := os.Getenv("JWT_HS256_KEY"); k != "" {
+		cfg.JWTKey = k
+	}
+	cfg.JWKSFile = tmp.JWKSFile
+	cfg.JWTIssuer = tmp.JWTIssuer
+	cfg.JWTAudience = tmp.JWTAudience
+	if cfg.JWTKey != "" || cfg.JWKSFile != "" {
+		if cfg.JWTIssuer == "" || cfg.JWTAudience == "" {
+			return cfg, fmt.Errorf("jwt_issuer and jwt_audience are required with JWT auth")
+		}
+		if cfg.JWTKey != "" && len(cfg.JWTKey) < 32 {
+			return cfg, fmt.Errorf("jwt_hs256_key must be at least 32 bytes")
+		}
+	}
 
 	return cfg, nil
 }
+
+// parseDuration parses a duration config field, substituting def when
+// the field is unset.
+func parseDuration(field, val, def string) (time.Duration, error) {
+	if val == "" {
+		val = def
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", field, val, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// portRange is an inclusive range of UDP ports.
+type portRange struct {
+	lo, hi uint16
+}
+
+// parsePortRange parses "5520" or "5520-5530".
+func parsePortRange(s string) (portRange, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hi = lo
+	}
+	a, err1 := strconv.ParseUint(lo, 10, 16)
+	b, err2 := strconv.ParseUint(hi, 10, 16)
+	if err1 != nil || err2 != nil || a == 0 || a > b {
+		return portRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	return portRange{lo: uint16(a), hi: uint16(b)}, nil
+}
+
+// destPolicy limits which backends routes may point at, so a caller
+// with write access to routes (or a misbehaving Bananasplit) can't turn
+// Peel into a reflector toward arbitrary hosts.
+//
+// A backend must be a literal IP inside one of cidrs (when any are
+// configured; hostnames are then rejected since the cell can't resolve
+// them) with a port inside one of ports (when any are configured).
+// Peel's own listen address is always refused, as is any loopback or
+// unspecified address on the listen port, so a route can never loop
+// traffic back into the relay.
+type destPolicy struct {
+	cidrs    []netip.Prefix
+	ports    []portRange
+	selfHost string // listen host as configured; "" for all interfaces
+	selfPort uint16
+}
+
+func newDestPolicy(listenAddr string, cidrs, ports []string) (*destPolicy, error) {
+	p := &destPolicy{
+		selfHost: hostOf(listenAddr),
+		selfPort: listenPort(listenAddr),
+	}
+	for _, c := range cidrs {
+		prefix, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend_cidrs entry %q", c)
+		}
+		p.cidrs = append(p.cidrs, prefix.Masked())
+	}
+	for _, s := range ports {
+		r, err := parsePortRange(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend_ports entry: %w", err)
+		}
+		p.ports = append(p.ports, r)
+	}
+	return p, nil
+}
+
+// Check returns why backend may not be used, or nil when it may. It
+// includes the syntactic validBackendAddr check.
+func (p *destPolicy) Check(backend string) error {
+	if !validBackendAddr(backend) {
+		return fmt.Errorf("invalid backend address")
+	}
+	host := hostOf(backend)
+	port := listenPort(backend)
+	addr, err := netip.ParseAddr(host)
+	isIP := err == nil
+	if isIP {
+		addr = addr.Unmap()
+	}
+
+	if port == p.selfPort {
+		if host == "" || host == "localhost" || host == p.selfHost ||
+			(isIP && (addr.IsLoopback() || addr.IsUnspecified())) {
+			return fmt.Errorf("backend is peel's own listen address")
+		}
+	}
+	if len(p.cidrs) > 0 {
+		if !isIP {
+			return fmt.Errorf("backend must be an IP address")
+		}
+		allowed := false
+		for _, c := range p.cidrs {
+			if c.Contains(addr) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("backend not in allowed networks")
+		}
+	}
+	if len(p.ports) > 0 {
+		allowed := false
+		for _, r := range p.ports {
+			if port >= r.lo && port <= r.hi {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("backend port not allowed")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDestPolicyCheck(t *testing.T) {
+	policy := func(listen string, cidrs, ports []string) *destPolicy {
+		p, err := newDestPolicy(listen, cidrs, ports)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	open := policy(":5520", nil, nil)
+	bound := policy("10.0.0.9:5520", nil, nil)
+	// 127.0.0.0/8 is allowed on purpose: the self-loop refusal must
+	// still win over it.
+	limited := policy("0.0.0.0:5520", []string{"10.0.0.0/8", "127.0.0.0/8"}, []string{"5520-5530", "6000"})
+
+	const (
+		ok       = ""
+		invalid  = "invalid backend address"
+		self     = "own listen address"
+		notIP    = "must be an IP"
+		notInNet = "not in allowed networks"
+		badPort  = "port not allowed"
+	)
+	tests := []struct {
+		name    string
+		p       *destPolicy
+		backend string
+		want    string // substring of the error; "" when allowed
+	}{
+		{"open ipv4", open, "10.0.0.5:5521", ok},
+		{"open ipv6", open, "[2001:db8::5]:5521", ok},
+		{"open hostname", open, "game-1:5520", ok},
+		{"open loopback other port", open, "127.0.0.1:5521", ok},
+
+		{"missing port", open, "10.0.0.5", invalid},
+		{"empty port", open, "10.0.0.5:", invalid},
+		{"ipv6 missing port", open, "[2001:db8::5]", invalid},
+		{"bare ipv6", open, "2001:db8::5", invalid},
+		{"port out of range", open, "10.0.0.5:70000", invalid},
+		{"port zero", open, "10.0.0.5:0", invalid},
+		{"empty", open, "", invalid},
+
+		{"self short form", open, ":5520", self},
+		{"self localhost", open, "localhost:5520", self},
+		{"self loopback", open, "127.0.0.1:5520", self},
+		{"self other loopback", open, "127.0.0.2:5520", self},
+		{"self ipv6 loopback", open, "[::1]:5520", self},
+		{"self unspecified", open, "0.0.0.0:5520", self},
+		{"self mapped loopback", open, "[::ffff:127.0.0.1]:5520", self},
+
+		{"bound self", bound, "10.0.0.9:5520", self},
+		{"bound loopback", bound, "127.0.0.1:5520", self},
+		{"bound neighbour", bound, "10.0.0.8:5520", ok},
+		{"bound other port", bound, "10.0.0.9:5521", ok},
+
+		{"cidr allowed", limited, "10.1.2.3:5525", ok},
+		{"cidr outside", limited, "192.168.1.1:5525", notInNet},
+		{"cidr mapped ipv4 allowed", limited, "[::ffff:10.1.2.3]:5525", ok},
+		{"cidr mapped ipv4 outside", limited, "[::ffff:192.168.1.1]:5525", notInNet},
+		{"cidr ipv6 outside", limited, "[2001:db8::5]:5525", notInNet},
+		{"cidr hostname", limited, "game-1:5525", notIP},
+		{"cidr self before allow", limited, "127.0.0.1:5520", self},
+		{"cidr loopback other port", limited, "127.0.0.1:5521", ok},
+		{"port range low", limited, "10.1.2.3:5520", ok},
+		{"port range high", limited, "10.1.2.3:5530", ok},
+		{"port single", limited, "10.1.2.3:6000", ok},
+		{"port below range", limited, "10.1.2.3:5519", badPort},
+		{"port above range", limited, "10.1.2.3:5531", badPort},
+		{"network before port", limited, "192.168.1.1:5519", notInNet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.Check(tt.backend)
+			if tt.want == ok {
+				if err != nil {
+					t.Errorf("Check(%q) = %v, want allowed", tt.backend, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Check(%q) = %v, want %q", tt.backend, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDestPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		cidrs, ports []string
+	}{
+		{"bad cidr", []string{"10.0.0.0/33"}, nil},
+		{"ip not cidr", []string{"10.0.0.1"}, nil},
+		{"bad port", nil, []string{"http"}},
+		{"port zero", nil, []string{"0"}},
+		{"reversed range", nil, []string{"5530-5520"}},
+		{"range past 65535", nil, []string{"5520-70000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newDestPolicy(":5520", tt.cidrs, tt.ports); err == nil {
+				t.Error("newDestPolicy accepted the config")
+			}
+		})
+	}
+}
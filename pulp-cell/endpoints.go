@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Bananasplit endpoint selection policies.
+//
+//   - "failover": always use the first healthy URL in config order, so
+//     replicas after the first only see traffic while it is down.
+//   - "round_robin": rotate over the healthy URLs on every request.
+const (
+	endpointFailover   = "failover"
+	endpointRoundRobin = "round_robin"
+)
+
+// endpointCooldown is how long a Bananasplit URL that just failed is
+// skipped before it is tried again.
+const endpointCooldown = 10 * time.Second
+
+// bananasplitEndpoint is one Bananasplit replica and its request stats.
+type bananasplitEndpoint struct {
+	URL      string
+	failures int   // consecutive
+	downAt   int64 // wall-time nanoseconds of the last failure
+	lastErr  string
+
+	requests uint64
+	errors   uint64
+	latency  *histogram
+}
+
+// up reports whether e may be picked at now.
+func (e *bananasplitEndpoint) up(now int64) bool {
+	return e.failures == 0 || now >= e.downAt+int64(endpointCooldown)
+}
+
+// endpointSet spreads Bananasplit requests over its replicas. A URL
+// that errors or times out is skipped for endpointCooldown; when every
+// URL is down, the one that failed longest ago is tried anyway.
+type endpointSet struct {
+	policy    string
+	endpoints []*bananasplitEndpoint
+	next      int // round_robin cursor
+}
+
+func newEndpointSet(urls []string, policy string) *endpointSet {
+	s := &endpointSet{policy: policy}
+	for _, u := range urls {
+		s.endpoints = append(s.endpoints, &bananasplitEndpoint{
+			URL:     strings.TrimSuffix(u, "/"),
+			latency: newHistogram(routeLatencyBuckets),
+		})
+	}
+	return s
+}
+
+// Pick returns the endpoint for the next request, skipping those in
+// tried (for retrying a request elsewhere). It returns nil when no
+// endpoint is configured or every one was tried.
+func (s *endpointSet) Pick(now int64, tried ...*bananasplitEndpoint) *bananasplitEndpoint {
+	n := len(s.endpoints)
+	start := 0
+	if s.policy == endpointRoundRobin && n > 0 {
+		start = s.next % n
+		s.next = (start + 1) % n
+	}
+	var fallback *bananasplitEndpoint
+	for i := range n {
+		e := s.endpoints[(start+i)%n]
+		if slices.Contains(tried, e) {
+			continue
+		}
+		if e.up(now) {
+			return e
+		}
+		if fallback == nil || e.downAt < fallback.downAt {
+			fallback = e
+		}
+	}
+	return fallback
+}
+
+// Record applies the outcome of one request to e.
+func (s *endpointSet) Record(e *bananasplitEndpoint, err error, now int64) {
+	e.requests++
+	if err == nil {
+		if e.failures > 0 {
+			log.Printf("Bananasplit endpoint up: %s", e.URL)
+		}
+		e.failures = 0
+		e.lastErr = ""
+		return
+	}
+	e.errors++
+	if e.failures == 0 && len(s.endpoints) > 1 {
+		log.Printf("Bananasplit endpoint down: %s (%v)", e.URL, err)
+	}
+	e.failures++
+	e.downAt = now
+	e.lastErr = err.Error()
+}
+
+// endpointFailed reports whether a fetch result means the endpoint
+// itself is unreachable or broken (transport error or 5xx), as opposed
+// to an answer Bananasplit gave on purpose.
+func endpointFailed(res fetchResult) error {
+	if res.Err != nil {
+		return res.Err
+	}
+	if res.Status >= 500 {
+		return fmt.Errorf("status %d", res.Status)
+	}
+	return nil
+}
+
+// validEndpointPolicy reports whether p is a known selection policy.
+func validEndpointPolicy(p string) bool {
+	return p == endpointFailover || p == endpointRoundRobin
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"time"
+)
+
+// Event types published on the relay's event bus.
+const (
+	evRouteSet            = "route.set"
+	evRouteChanged        = "route.changed"
+	evRouteDeleted        = "route.deleted"
+	evSessionCreated      = "session.created"
+	evSessionClosed       = "session.closed"
+	evSessionBackend      = "session.backend_changed"
+	evRouteRequestFailed  = "route_request.failed"
+	evNegativeCacheInsert = "negative_cache.insert"
+	evBackendUnhealthy    = "backend.unhealthy"
+	evBackendHealthy      = "backend.healthy"
+)
+
+// relayEvent is one structured route or session change. Fields that
+// don't apply to a type are left empty.
+type relayEvent struct {
+	ID         uint64    `json:"id"`
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	PlayerIP   string    `json:"player_ip,omitempty"` // route key or session flow
+	Backend    string    `json:"backend,omitempty"`
+	OldBackend string    `json:"old_backend,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// eventBus keeps the most recent events in a fixed-size ring, each
+// with a monotonically increasing ID, so readers can resume from the
+// last ID they saw. Subscribers are called synchronously on publish —
+// they run on the step goroutine and must not block.
+type eventBus struct {
+	ring   []relayEvent
+	next   int    // ring index of the next write
+	lastID uint64 // ID of the newest event; 0 before the first
+	subs   []func(relayEvent)
+}
+
+func newEventBus(size int) *eventBus {
+	return &eventBus{ring: make([]relayEvent, 0, size)}
+}
+
+// Subscribe registers fn to receive every future event.
+func (b *eventBus) Subscribe(fn func(relayEvent)) {
+	b.subs = append(b.subs, fn)
+}
+
+// Publish stamps ev with the next ID and the current time, stores it
+// and hands it to the subscribers.
+func (b *eventBus) Publish(ev relayEvent) {
+	b.lastID++
+	ev.ID = b.lastID
+	ev.Time = time.Now().UTC()
+	if cap(b.ring) > 0 {
+		if len(b.ring) < cap(b.ring) {
+			b.ring = append(b.ring, ev)
+		} else {
+			b.ring[b.next] = ev
+		}
+		b.next = (b.next + 1) % cap(b.ring)
+	}
+	for _, fn := range b.subs {
+		fn(ev)
+	}
+}
+
+// Since returns the buffered events with ID greater than id, oldest
+// first. Events that have already rotated out of the ring are lost.
+func (b *eventBus) Since(id uint64) []relayEvent {
+	var out []relayEvent
+	n := len(b.ring)
+	// Until the ring fills, the oldest entry is at 0; afterwards it is
+	// the slot about to be overwritten.
+	start := 0
+	if n == cap(b.ring) {
+		start = b.next
+	}
+	for i := 0; i < n; i++ {
+		ev := b.ring[(start+i)%n]
+		if ev.ID > id {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// emit publishes an event on the relay's bus.
+func (r *Relay) emit(ev relayEvent) {
+	r.events.Publish(ev)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+
+	"github.com/BananaLabs-OSS/Fiber/pulp"
+)
+
+// fetchStepBudget is how long Poll keeps starting queued calls within
+// one step. A call that is already running always finishes (or times
+// out); the budget only stops the next one from starting.
+const fetchStepBudget = 50 * time.Millisecond
+
+// fetchResult is the outcome of one outbound HTTP call, copied out of
+// the pulp response so callbacks never hold host-owned memory.
+type fetchResult struct {
+	Status int
+	Body   []byte
+	Err    error
+}
+
+// fetchCall is one queued request and the callback waiting on it.
+type fetchCall struct {
+	req pulp.HTTPFetchRequest
+	cb  func(fetchResult)
+}
+
+// fetchQueue defers outbound HTTP calls from wherever they are made
+// (the packet path, the API, a Step) to the start of the next step.
+//
+// pulp exposes only the blocking pulp.HTTP.Fetch — there is no
+// submit/poll host API — and Go on wasip1 is a single thread, so a
+// goroutine would not make the call concurrent. Each call therefore
+// still blocks the step loop, and UDP relaying with it, for as long as
+// it takes, up to its Timeout. What the queue buys is that no call is
+// made in the middle of handling a packet or a request, calls run in
+// order from one place, and Poll stops starting new ones once a step
+// has spent fetchStepBudget on them. Keep request timeouts short.
+type fetchQueue struct {
+	queue []fetchCall
+}
+
+func newFetchQueue() *fetchQueue {
+	return &fetchQueue{}
+}
+
+// Submit queues req. cb runs on the step that makes the call.
+func (f *fetchQueue) Submit(req pulp.HTTPFetchRequest, cb func(fetchResult)) {
+	f.queue = append(f.queue, fetchCall{req: req, cb: cb})
+}
+
+// Poll makes queued calls in order and runs their callbacks, until the
+// queue is empty or the step's budget is spent. At least one call is
+// made per step so the queue always drains. Calls submitted by a
+// callback join the end of the queue.
+func (f *fetchQueue) Poll() {
+	start := time.Now()
+	for len(f.queue) > 0 {
+		call := f.queue[0]
+		f.queue[0] = fetchCall{}
+		f.queue = f.queue[1:]
+
+		var res fetchResult
+		resp, err := pulp.HTTP.Fetch(call.req)
+		if err != nil {
+			res.Err = err
+		} else {
+			res.Status = int(resp.Status)
+			res.Body = append([]byte(nil), resp.Body...)
+		}
+		call.cb(res)
+
+		if time.Since(start) >= fetchStepBudget {
+			return
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BananaLabs-OSS/Fiber/pulp"
+	"github.com/BananaLabs-OSS/Fiber/pulp/udp"
+)
+
+// Health check modes.
+//
+//   - "off": no probing; every backend is assumed healthy.
+//   - "udp": send health_payload to the backend from a shared probe
+//     socket and count any datagram back from it within health_timeout
+//     as alive. Replies are matched by source address, so this needs
+//     routes to use literal ip:port backends.
+//   - "http": GET health_url with {host} replaced by the backend host;
+//     any 2xx is alive.
+const (
+	healthOff  = "off"
+	healthUDP  = "udp"
+	healthHTTP = "http"
+)
+
+// maxHTTPHealthTimeout caps health_timeout for HTTP probes. Each probe
+// is a blocking pulp Fetch that stalls the step loop, and UDP relaying
+// with it, until the backend answers or the timeout passes, so like
+// maxWatchHold it stays within one step's fetch budget. UDP probes are
+// sent on a socket and don't block, so their timeout isn't capped.
+const maxHTTPHealthTimeout = fetchStepBudget
+
+// backendHealth is the probe state of one distinct backend.
+type backendHealth struct {
+	Backend    string    `json:"backend"`
+	Healthy    bool      `json:"healthy"`
+	Failures   int       `json:"consecutive_failures"`
+	LastError  string    `json:"last_error,omitempty"`
+	LastChange time.Time `json:"last_change"`
+
+	probing  bool
+	deadline uint64 // wall-time nanoseconds; UDP probe reply due by
+}
+
+// healthChecker probes every backend referenced by Router each
+// interval. A backend is marked unhealthy after threshold consecutive
+// failed probes and healthy again on the first success. When a fallback
+// backend is configured, sessions on an unhealthy backend are moved to
+// it and new sessions for routes pointing at it start there instead;
+// the route itself is left alone so Bananasplit, which sees the
+// unhealthy backend on GET /backends, can reroute.
+type healthChecker struct {
+	relay     *Relay
+	mode      string
+	interval  time.Duration
+	timeout   time.Duration
+	threshold int
+	payload   []byte
+	url       string
+	fallback  string
+
+	probeSock *udp.Socket
+	backends  map[string]*backendHealth
+	next      uint64 // wall-time nanoseconds of the next round
+}
+
+func newHealthChecker(relay *Relay, cfg appConfig) *healthChecker {
+	return &healthChecker{
+		relay:     relay,
+		mode:      cfg.HealthCheck,
+		interval:  cfg.HealthInterval,
+		timeout:   cfg.HealthTimeout,
+		threshold: cfg.HealthThreshold,
+		payload:   []byte(cfg.HealthPayload),
+		url:       cfg.HealthURL,
+		fallback:  cfg.FallbackBackend,
+		backends:  make(map[string]*backendHealth),
+	}
+}
+
+// Start opens the UDP probe socket when UDP probing is enabled.
+func (h *healthChecker) Start() error {
+	if h.mode != healthUDP {
+		return nil
+	}
+	sock, err := udp.Listen("", 64*1024)
+	if err != nil {
+		return fmt.Errorf("health probe listen: %w", err)
+	}
+	sock.OnPacket(func(pkt udp.Packet) {
+		if b, ok := h.backends[pkt.SrcAddr]; ok && b.probing {
+			b.probing = false
+			h.record(b, nil)
+		}
+	})
+	h.probeSock = sock
+	return nil
+}
+
+// Stop closes the probe socket.
+func (h *healthChecker) Stop() {
+	if h.probeSock != nil {
+		_ = h.probeSock.Close()
+		h.probeSock = nil
+	}
+}
+
+// Step runs once per step: it expires overdue UDP probes and starts a
+// new probe round every interval.
+func (h *healthChecker) Step(wallNanos uint64) {
+	if h.mode == healthOff {
+		return
+	}
+	for _, b := range h.backends {
+		if h.mode == healthUDP && b.probing && wallNanos > b.deadline {
+			b.probing = false
+			h.record(b, fmt.Errorf("no reply within %s", h.timeout))
+		}
+	}
+	if wallNanos < h.next {
+		return
+	}
+	h.next = wallNanos + uint64(h.interval)
+
+	// Track exactly the backends routes point at, pools expanded to
+	// their members; forget the rest.
+	want := make(map[string]bool)
+	for _, e := range h.relay.router.Entries() {
+		if name, ok := poolName(e.Backend); ok {
+			if p, exists := h.relay.pools[name]; exists {
+				for _, m := range p.Backends {
+					want[m.Addr] = true
+				}
+			}
+			continue
+		}
+		want[e.Backend] = true
+	}
+	for backend := range h.backends {
+		if !want[backend] {
+			delete(h.backends, backend)
+		}
+	}
+	for backend := range want {
+		b, ok := h.backends[backend]
+		if !ok {
+			b = &backendHealth{Backend: backend, Healthy: true, LastChange: time.Now().UTC()}
+			h.backends[backend] = b
+		}
+		h.probe(b, wallNanos)
+	}
+}
+
+// probe sends one probe to b unless the previous one is still pending.
+func (h *healthChecker) probe(b *backendHealth, wallNanos uint64) {
+	if b.probing {
+		return
+	}
+	switch h.mode {
+	case healthUDP:
+		if h.probeSock == nil {
+			return
+		}
+		b.probing = true
+		b.deadline = wallNanos + uint64(h.timeout)
+		if _, err := h.probeSock.Send(b.Backend, h.payload); err != nil {
+			b.probing = false
+			h.record(b, err)
+		}
+	case healthHTTP:
+		b.probing = true
+		h.relay.fetcher.Submit(pulp.HTTPFetchRequest{
+			Method:  "GET",
+			URL:     strings.ReplaceAll(h.url, "{host}", hostOf(b.Backend)),
+			Timeout: h.timeout,
+		}, func(res fetchResult) {
+			b.probing = false
+			if h.backends[b.Backend] != b {
+				return // backend dropped from the routes meanwhile
+			}
+			if res.Err != nil {
+				h.record(b, res.Err)
+			} else if res.Status < 200 || res.Status > 299 {
+				h.record(b, fmt.Errorf("health status %d", res.Status))
+			} else {
+				h.record(b, nil)
+			}
+		})
+	}
+}
+
+// record applies one probe outcome and handles state transitions.
+func (h *healthChecker) record(b *backendHealth, err error) {
+	if err == nil {
+		b.Failures = 0
+		b.LastError = ""
+		if !b.Healthy {
+			b.Healthy = true
+			b.LastChange = time.Now().UTC()
+			log.Printf("Backend healthy: %s", b.Backend)
+			h.relay.emit(relayEvent{Type: evBackendHealthy, Backend: b.Backend})
+		}
+		return
+	}
+	b.Failures++
+	b.LastError = err.Error()
+	if b.Healthy && b.Failures >= h.threshold {
+		b.Healthy = false
+		b.LastChange = time.Now().UTC()
+		log.Printf("Backend unhealthy: %s (%v)", b.Backend, err)
+		h.relay.emit(relayEvent{Type: evBackendUnhealthy, Backend: b.Backend, Error: b.LastError})
+		h.failover(b.Backend)
+	}
+}
+
+// failover moves every session on backend to the fallback backend.
+func (h *healthChecker) failover(backend string) {
+	if h.fallback == "" || h.fallback == backend {
+		return
+	}
+	r := h.relay
+	var flows []string
+	for flow, sess := range r.sessions {
+		if sess.Backend == backend {
+			flows = append(flows, flow)
+		}
+	}
+	sort.Strings(flows)
+	for _, flow := range flows {
+		if r.hotSwap {
+			r.swapSessionBackend(flow, h.fallback)
+		} else {
+			r.closeSessionLocked(flow, closeBackendChange)
+		}
+		log.Printf("Session failed over: %s %s → %s", flow, backend, h.fallback)
+		r.emit(relayEvent{Type: evSessionBackend, PlayerIP: flow, Backend: h.fallback, OldBackend: backend, Reason: "failover"})
+	}
+}
+
+// resolve returns the backend a new session should use: backend
+// itself, or the fallback while backend is unhealthy.
+func (h *healthChecker) resolve(backend string) string {
+	if h.fallback == "" {
+		return backend
+	}
+	if b, ok := h.backends[backend]; ok && !b.Healthy {
+		return h.fallback
+	}
+	return backend
+}
+
+// healthy reports whether backend may take new sessions: true unless
+// it is tracked and marked unhealthy.
+func (h *healthChecker) healthy(backend string) bool {
+	b, ok := h.backends[backend]
+	return !ok || b.Healthy
+}
+
+// List returns the health of every tracked backend, sorted by address.
+func (h *healthChecker) List() []backendHealth {
+	out := make([]backendHealth, 0, len(h.backends))
+	for _, b := range h.backends {
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Backend < out[j].Backend })
+	return out
+}
+
+// unhealthyCount returns how many tracked backends are down.
+func (h *healthChecker) unhealthyCount() int {
+	n := 0
+	for _, b := range h.backends {
+		if !b.Healthy {
+			n++
+		}
+	}
+	return n
+}
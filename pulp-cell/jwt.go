@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtLeeway absorbs clock skew between the token issuer and the host
+// when checking exp, nbf and iat.
+const jwtLeeway = 30 * time.Second
+
+// jwksMethods are the asymmetric algorithms accepted for JWKS keys. The
+// key type still has to match: an RS256 token never verifies against an
+// EC key.
+var jwksMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// peelClaims are the claims Peel reads from a control-API JWT. scope is
+// the OAuth-style space-separated list; scopes Peel doesn't know are
+// ignored so one token can serve several services. peel_players, when
+// present, limits the player IPs and CIDRs the caller may mutate; an
+// empty list permits none.
+type peelClaims struct {
+	Scope   string   `json:"scope"`
+	Players []string `json:"peel_players"`
+	jwt.RegisteredClaims
+}
+
+// jwtVerifier validates bearer JWTs signed with the HS256 shared key or
+// one of the static JWKS keys. Issuer, audience and expiry are always
+// checked; a token without exp is refused.
+type jwtVerifier struct {
+	hmacKey []byte
+	keys    map[string]any // JWKS public keys by kid
+	parser  *jwt.Parser
+}
+
+// newJWTVerifier returns nil when JWT auth is not configured. The JWKS
+// file is read once here; rotating its keys means restarting the cell.
+func newJWTVerifier(cfg appConfig) (*jwtVerifier, error) {
+	if cfg.JWTKey == "" && cfg.JWKSFile == "" {
+		return nil, nil
+	}
+	v := &jwtVerifier{keys: make(map[string]any)}
+	var methods []string
+	if cfg.JWTKey != "" {
+		v.hmacKey = []byte(cfg.JWTKey)
+		methods = append(methods, "HS256")
+	}
+	if cfg.JWKSFile != "" {
+		data, err := os.ReadFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("read jwt_jwks_file: %w", err)
+		}
+		if v.keys, err = parseJWKS(data); err != nil {
+			return nil, fmt.Errorf("jwt_jwks_file %s: %w", cfg.JWKSFile, err)
+		}
+		methods = append(methods, jwksMethods...)
+	}
+	v.parser = jwt.NewParser(
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(cfg.JWTIssuer),
+		jwt.WithAudience(cfg.JWTAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	)
+	return v, nil
+}
+
+// key picks the verification key for t.
+func (v *jwtVerifier) key(t *jwt.Token) (any, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+		return v.hmacKey, nil
+	}
+	kid, _ := t.Header["kid"].(string)
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown kid %q", kid)
+}
+
+// Verify parses raw and returns the caller it authenticates, or the
+// rejection reason ("expired" or "invalid") and the error behind it.
+func (v *jwtVerifier) Verify(raw string) (*caller, string, error) {
+	var claims peelClaims
+	if _, err := v.parser.ParseWithClaims(raw, &claims, v.key); err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, "expired", err
+		}
+		return nil, "invalid", err
+	}
+	if claims.Subject == "" {
+		return nil, "invalid", errors.New("missing sub")
+	}
+	cl := &caller{Name: claims.Subject, Via: "jwt"}
+	for _, s := range strings.Fields(claims.Scope) {
+		if validScope(s) {
+			cl.Scopes = append(cl.Scopes, s)
+		}
+	}
+	if claims.Players != nil {
+		cl.Players = make([]netip.Prefix, 0, len(claims.Players))
+		for _, p := range claims.Players {
+			prefix, ok := playerPrefix(p)
+			if !ok {
+				return nil, "invalid", fmt.Errorf("invalid peel_players entry %q", p)
+			}
+			cl.Players = append(cl.Players, prefix)
+		}
+	}
+	return cl, "", nil
+}
+
+// playerPrefix parses an IP or CIDR into the prefix it covers.
+func playerPrefix(s string) (netip.Prefix, bool) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), true
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	a = a.Unmap()
+	return netip.PrefixFrom(a, a.BitLen()), true
+}
+
+// parseJWKS decodes the RSA and EC public keys of a JWKS document.
+// Keys of other types (and "use": "enc" keys) are skipped.
+func parseJWKS(data []byte) (map[string]any, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	keys := make(map[string]any)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if _, dup := keys[k.Kid]; dup {
+			return nil, fmt.Errorf("duplicate kid %q", k.Kid)
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := decodeBigInt(k.N)
+			e, err2 := decodeBigInt(k.E)
+			if err1 != nil || err2 != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("key %q: invalid RSA parameters", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("key %q: unsupported curve %q", k.Kid, k.Crv)
+			}
+			x, err1 := decodeBigInt(k.X)
+			y, err2 := decodeBigInt(k.Y)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("key %q: invalid EC parameters", k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable signing keys")
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
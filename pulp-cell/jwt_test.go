@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testJWTKey = "0123456789abcdef0123456789abcdef"
+
+// testClaims returns valid claims for the test verifiers, expiring in
+// an hour, with the given overrides applied (a nil value deletes).
+func testClaims(over jwt.MapClaims) jwt.MapClaims {
+	c := jwt.MapClaims{
+		"iss":   "bananalabs",
+		"aud":   "peel",
+		"sub":   "bananasplit",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "route-write",
+	}
+	for k, v := range over {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func signJWT(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// testVerifiers returns a verifier with only the HS256 key, one with
+// only a JWKS holding ecKey as "ec1", and the keys themselves.
+func testVerifiers(t *testing.T) (hs, jwks *jwtVerifier, ecKey *ecdsa.PrivateKey) {
+	t.Helper()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	doc := `{"keys": [{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": "` +
+		b64(ecKey.X.FillBytes(make([]byte, 32))) + `", "y": "` +
+		b64(ecKey.Y.FillBytes(make([]byte, 32))) + `"}]}`
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	base := appConfig{JWTIssuer: "bananalabs", JWTAudience: "peel"}
+	hsCfg := base
+	hsCfg.JWTKey = testJWTKey
+	if hs, err = newJWTVerifier(hsCfg); err != nil {
+		t.Fatal(err)
+	}
+	jwksCfg := base
+	jwksCfg.JWKSFile = path
+	if jwks, err = newJWTVerifier(jwksCfg); err != nil {
+		t.Fatal(err)
+	}
+	return hs, jwks, ecKey
+}
+
+func TestJWTVerify(t *testing.T) {
+	hs, jwks, ecKey := testVerifiers(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmac := []byte(testJWTKey)
+	ago := func(d time.Duration) int64 { return time.Now().Add(-d).Unix() }
+
+	tests := []struct {
+		name   string
+		v      *jwtVerifier
+		token  string
+		reason string // "" when the token must verify
+	}{
+		{"hs256", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(nil)), ""},
+		{"es256 by kid", jwks, signJWT(t, jwt.SigningMethodES256, ecKey, "ec1", testClaims(nil)), ""},
+		{"es256 single key without kid", jwks, signJWT(t, jwt.SigningMethodES256, ecKey, "", testClaims(nil)), ""},
+		{"audience list", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"aud": []string{"other", "peel"}})), ""},
+
+		{"hs384", hs, signJWT(t, jwt.SigningMethodHS384, hmac, "", testClaims(nil)), "invalid"},
+		{"alg none", hs, signJWT(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", testClaims(nil)), "invalid"},
+		{"rs256 without jwks", hs, signJWT(t, jwt.SigningMethodRS256, rsaKey, "", testClaims(nil)), "invalid"},
+		{"hs256 without key", jwks, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(nil)), "invalid"},
+		{"unknown kid", jwks, signJWT(t, jwt.SigningMethodES256, ecKey, "ec2", testClaims(nil)), "invalid"},
+		{"wrong hmac key", hs, signJWT(t, jwt.SigningMethodHS256, []byte("fedcba9876543210fedcba9876543210"), "", testClaims(nil)), "invalid"},
+
+		{"wrong issuer", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"iss": "someone-else"})), "invalid"},
+		{"missing issuer", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"iss": nil})), "invalid"},
+		{"wrong audience", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"aud": "potassium"})), "invalid"},
+		{"missing audience", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"aud": nil})), "invalid"},
+
+		{"missing exp", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"exp": nil})), "invalid"},
+		{"expired within leeway", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"exp": ago(10 * time.Second)})), ""},
+		{"expired past leeway", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"exp": ago(time.Minute)})), "expired"},
+		{"not yet valid within leeway", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"nbf": ago(-10 * time.Second)})), ""},
+		{"not yet valid past leeway", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"nbf": ago(-time.Minute)})), "invalid"},
+
+		{"missing sub", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"sub": nil})), "invalid"},
+		{"bad peel_players", hs, signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(jwt.MapClaims{"peel_players": []string{"not-an-ip"}})), "invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl, reason, err := tt.v.Verify(tt.token)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if cl.Name != "bananasplit" || cl.Via != "jwt" {
+					t.Errorf("caller = %+v", cl)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Verify accepted the token")
+			}
+			if reason != tt.reason {
+				t.Errorf("reason = %q, want %q (%v)", reason, tt.reason, err)
+			}
+		})
+	}
+}
+
+func TestJWTScopes(t *testing.T) {
+	hs, _, _ := testVerifiers(t)
+	tok := signJWT(t, jwt.SigningMethodHS256, []byte(testJWTKey), "", testClaims(jwt.MapClaims{"scope": "route-write billing:read"}))
+	cl, _, err := hs.Verify(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cl.Scopes) != 1 || cl.Scopes[0] != scopeRouteWrite {
+		t.Errorf("scopes = %v, want [%s]", cl.Scopes, scopeRouteWrite)
+	}
+	if !cl.allows(scopeRead) || cl.allows(scopeSessionAdmin) {
+		t.Errorf("route-write caller: read %v, session-admin %v", cl.allows(scopeRead), cl.allows(scopeSessionAdmin))
+	}
+}
+
+func TestJWTPlayers(t *testing.T) {
+	hs, _, _ := testVerifiers(t)
+	hmac := []byte(testJWTKey)
+
+	tests := []struct {
+		name    string
+		players any // peel_players claim; nil leaves it out
+		key     string
+		want    bool
+	}{
+		{"no claim, ip", nil, "203.0.113.50", true},
+		{"no claim, default", nil, "default", true},
+		{"no claim, cidr", nil, "0.0.0.0/0", true},
+
+		{"empty claim, ip", []string{}, "203.0.113.50", false},
+
+		{"inside cidr", []string{"10.20.0.0/16"}, "10.20.3.4", true},
+		{"outside cidr", []string{"10.20.0.0/16"}, "10.21.3.4", false},
+		{"flow inside cidr", []string{"10.20.0.0/16"}, "10.20.3.4:40000", true},
+		{"flow outside cidr", []string{"10.20.0.0/16"}, "10.21.3.4:40000", false},
+		{"narrower cidr", []string{"10.20.0.0/16"}, "10.20.8.0/24", true},
+		{"wider cidr", []string{"10.20.0.0/16"}, "10.0.0.0/8", false},
+		{"overlapping cidr", []string{"10.20.0.0/16"}, "10.20.0.0/15", false},
+		{"default route", []string{"10.20.0.0/16"}, "default", false},
+		{"mapped ipv4", []string{"10.20.0.0/16"}, "::ffff:10.20.3.4", true},
+
+		{"single ip", []string{"203.0.113.50"}, "203.0.113.50", true},
+		{"neighbour of single ip", []string{"203.0.113.50"}, "203.0.113.51", false},
+		{"ipv6 inside", []string{"2001:db8::/32"}, "2001:db8::1", true},
+		{"ipv6 outside", []string{"2001:db8::/32"}, "2001:db9::1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			over := jwt.MapClaims{}
+			if tt.players != nil {
+				over["peel_players"] = tt.players
+			}
+			cl, _, err := hs.Verify(signJWT(t, jwt.SigningMethodHS256, hmac, "", testClaims(over)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cl.mayMutate(tt.key); got != tt.want {
+				t.Errorf("mayMutate(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+			if got, want := cl.unrestricted(), tt.players == nil; got != want {
+				t.Errorf("unrestricted() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestJWTAccessLists(t *testing.T) {
+	hs, _, _ := testVerifiers(t)
+	hmac := []byte(testJWTKey)
+	restricted, _, err := hs.Verify(signJWT(t, jwt.SigningMethodHS256, hmac, "",
+		testClaims(jwt.MapClaims{"scope": "session-admin", "peel_players": []string{"10.20.0.0/16"}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrestricted, _, err := hs.Verify(signJWT(t, jwt.SigningMethodHS256, hmac, "",
+		testClaims(jwt.MapClaims{"scope": "session-admin"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cl     *caller
+		action string
+		key    string
+		want   bool
+	}{
+		{"restricted blocks inside", restricted, auditBlocklistAdd, "10.20.1.1", true},
+		{"restricted blocks outside", restricted, auditBlocklistAdd, "203.0.113.5", false},
+		{"restricted unblocks inside", restricted, auditBlocklistRemove, "10.20.1.1", true},
+		{"restricted unblocks outside", restricted, auditBlocklistRemove, "203.0.113.5", false},
+		// An allowlist entry shuts out every unlisted player, so even
+		// one inside the caller's range is refused.
+		{"restricted allows inside", restricted, auditAllowlistAdd, "10.20.1.1", false},
+		{"restricted disallows inside", restricted, auditAllowlistRemove, "10.20.1.1", false},
+		{"unrestricted allows", unrestricted, auditAllowlistAdd, "203.0.113.5", true},
+		{"unrestricted disallows", unrestricted, auditAllowlistRemove, "203.0.113.5", true},
+		{"auth off allows", nil, auditAllowlistAdd, "203.0.113.5", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mayChangeAccess(tt.cl, tt.action, tt.key); got != tt.want {
+				t.Errorf("mayChangeAccess(%s, %q) = %v, want %v", tt.action, tt.key, got, tt.want)
+			}
+		})
+	}
+}
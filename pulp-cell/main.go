@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/BananaLabs-OSS/Fiber/pulp"
 	pulpgin "github.com/BananaLabs-OSS/Fiber/pulp/gin"
@@ -37,22 +39,51 @@ func bootstrap(configBytes []byte) error {
 	}
 
 	// Auth posture: auth-available-not-mandatory. The mutating control API
-	// (POST /routes, DELETE /routes/:ip, DELETE /sessions/:ip) is gated on
-	// X-Service-Token ONLY when SERVICE_TOKEN is configured (non-empty).
+	// is gated on X-Service-Token ONLY when a token is configured
+	// (SERVICE_TOKEN, or named, scoped service_tokens entries) or on a
+	// bearer JWT when a JWT key is.
 	// The control API is internal-only-bounded — the cell publishes only
 	// the UDP listener; the HTTP control port is reachable only from
 	// sibling cells on the Pulp host. So when no token is set we start and
 	// serve unauthenticated (today's behavior, no outage). To ENABLE auth,
-	// set SERVICE_TOKEN HERE *and* have the callers (Bananasplit PeelClient,
-	// Potassium relay.Client) send the same X-Service-Token — in lockstep.
+	// configure a token HERE *and* have the callers (Bananasplit PeelClient,
+	// Potassium relay.Client) send it as X-Service-Token. Named tokens can
+	// overlap, so later rotations don't need a lockstep deploy.
 	// Deliberately NOT fail-closed: an empty token must not block startup.
+	// A JWT key that IS configured but unusable does, though: better than
+	// silently serving with auth the operator thought was on.
+	verifier, err := newJWTVerifier(cfg)
+	if err != nil {
+		return fmt.Errorf("jwt auth: %w", err)
+	}
 
 	// --- Relay ---
-	relay := New(cfg.ListenAddr, cfg.BananasplitURL, cfg.BufferSize, cfg.IdleTimeout)
+	relay := New(cfg)
+
+	// Restore the last route snapshot before the socket opens so the
+	// first packets after a restart hit warm routes instead of a
+	// thundering herd of /route-request calls. A bad snapshot is logged
+	// and skipped — it must never keep the relay down.
+	state := newPersister(relay, cfg)
+	if err := state.Restore(); err != nil {
+		log.Printf("State restore failed: %v", err)
+	}
+
 	if err := relay.Start(); err != nil {
 		return fmt.Errorf("relay start: %w", err)
 	}
 
+	// Reconciliation with Bananasplit's authoritative route set. The
+	// first sync fires on the first step, then every SyncInterval.
+	syncer := newRouteSyncer(relay, cfg.SyncInterval)
+
+	// Change feed from Bananasplit. Runs alongside the pull path, which
+	// stays the fallback whenever the stream is down.
+	watcher := newRouteWatcher(relay, cfg.Watch, cfg.WatchHold)
+
+	// Session lifecycle notifications back to Bananasplit.
+	webhooks := newWebhookSender(relay, cfg)
+
 	// --- HTTP control API ---
 	//
 	// Bind an alt listener at cfg.APIAddr only if it differs from the
@@ -68,9 +99,20 @@ func bootstrap(configBytes []byte) error {
 		}
 	}
 	r := pulpgin.New()
-	registerRoutes(r, relay, cfg.ServiceToken)
-	if cfg.ServiceToken != "" {
-		log.Printf("Control-API auth ENABLED (X-Service-Token required on mutating routes)")
+	auth := newTokenAuth(cfg, verifier)
+	registerRoutes(r, relay, auth)
+	if auth.enabled() {
+		for _, t := range cfg.ServiceTokens {
+			expires := "never"
+			if !t.Expires.IsZero() {
+				expires = t.Expires.UTC().Format(time.RFC3339)
+			}
+			log.Printf("Control-API token %s: scopes %s, expires %s", t.Name, strings.Join(t.Scopes, ","), expires)
+		}
+		if verifier != nil {
+			log.Printf("Control-API JWT auth: issuer %s, audience %s", cfg.JWTIssuer, cfg.JWTAudience)
+		}
+		log.Printf("Control-API auth ENABLED (X-Service-Token or bearer JWT required on mutating routes; reads: %v)", cfg.AuthReads)
 	} else {
 		log.Printf("Control-API auth OFF (SERVICE_TOKEN empty); to enable, set SERVICE_TOKEN here AND have callers (Bananasplit PeelClient, Potassium relay.Client) send X-Service-Token")
 	}
@@ -89,12 +131,26 @@ func bootstrap(configBytes []byte) error {
 		if err := udp.Dispatch(ev); err != nil {
 			return err
 		}
+		relay.PollFetches()
 		relay.SweepIdle(ev.WallTime)
+		relay.SweepPending(ev.WallTime)
+		relay.SweepRoutes(ev.WallTime)
+		relay.SweepNegativeCache(ev.WallTime)
+		relay.SweepRateLimits(ev.WallTime)
+		relay.SweepAccess(ev.WallTime)
+		state.Step(ev.WallTime)
+		syncer.Step(ev.WallTime)
+		watcher.Step(ev.WallTime)
+		webhooks.Step(ev.WallTime)
+		relay.health.Step(ev.WallTime)
 		return r.Dispatch(ev)
 	})
 
 	pulp.OnShutdown(func() error {
 		log.Println("Shutting down...")
+		if err := state.Save(); err != nil {
+			log.Printf("State snapshot failed: %v", err)
+		}
 		relay.Stop()
 		return nil
 	})
@@ -109,5 +165,9 @@ func bootstrap(configBytes []byte) error {
 	log.Printf("API listening on %s", cfg.APIAddr)
 	log.Printf("Bananasplit URL: %s", cfg.BananasplitURL)
 	log.Printf("Buffer size: %d bytes", cfg.BufferSize)
+	// Cell-only, after the native lines like the idle timeout note.
+	if len(cfg.BananasplitURLs) > 1 {
+		log.Printf("Bananasplit replicas: %s (%s)", strings.Join(cfg.BananasplitURLs[1:], ", "), cfg.BananasplitPolicy)
+	}
 	return nil
 }
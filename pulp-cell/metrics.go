@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Packet directions tracked by relayMetrics. "player_in" is a datagram
+// received on the listen socket, "backend_out" its forward to the
+// backend, "backend_in" a backend reply and "player_out" that reply
+// written back to the player.
+const (
+	dirPlayerIn   = "player_in"
+	dirBackendOut = "backend_out"
+	dirBackendIn  = "backend_in"
+	dirPlayerOut  = "player_out"
+)
+
+// Session close reasons, used as the "reason" label on
+// peel_sessions_closed_total.
+const (
+	closeIdle          = "idle"
+	closeAPI           = "api"
+	closeRouteDeleted  = "route_deleted"
+	closeBackendChange = "backend_changed"
+	closeBlocked       = "blocked"
+)
+
+// routeLatencyBuckets are the upper bounds (seconds) of the
+// Bananasplit route-request latency histogram.
+var routeLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// histogram is a fixed-bucket Prometheus histogram.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, non-cumulative; last slot is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// Observe records one sample in seconds.
+func (h *histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// relayMetrics holds the relay's counters. Plain integers — every
+// update happens on the step goroutine.
+type relayMetrics struct {
+	packets map[string]uint64 // by direction
+	bytes   map[string]uint64 // by direction
+
+	sessionsCreated uint64
+	sessionsClosed  map[string]uint64 // by reason
+
+	negativeCacheHits uint64
+	accessDropped     map[string]uint64 // by list
+	routeRequests     uint64
+	routeErrors       map[string]uint64 // by error class
+	routeLatency      *histogram
+
+	idleSweeps uint64
+
+	routeSyncs      uint64
+	routeSyncErrors uint64
+	routeDrift      map[string]uint64 // by kind: added, changed, removed
+
+	watchUp      bool
+	watchChanges uint64
+	watchErrors  uint64
+
+	webhookSent    uint64
+	webhookErrors  uint64
+	webhookDropped uint64
+}
+
+func newRelayMetrics() *relayMetrics {
+	return &relayMetrics{
+		packets:        make(map[string]uint64),
+		bytes:          make(map[string]uint64),
+		sessionsClosed: make(map[string]uint64),
+		routeErrors:    make(map[string]uint64),
+		accessDropped:  make(map[string]uint64),
+		routeLatency:   newHistogram(routeLatencyBuckets),
+		routeDrift:     make(map[string]uint64),
+	}
+}
+
+// countPacket records one datagram of n bytes in direction dir.
+func (m *relayMetrics) countPacket(dir string, n int) {
+	m.packets[dir]++
+	m.bytes[dir] += uint64(n)
+}
+
+// observeRouteRequest records a finished Bananasplit lookup.
+func (m *relayMetrics) observeRouteRequest(elapsed time.Duration, err error) {
+	m.routeRequests++
+	m.routeLatency.Observe(elapsed.Seconds())
+	if err != nil {
+		m.routeErrors[routeErrorClass(err)]++
+	}
+}
+
+// WritePrometheus renders every relay metric in the Prometheus text
+// exposition format.
+func (r *Relay) WritePrometheus(b *strings.Builder) {
+	m := r.metrics
+
+	writeHelp(b, "peel_packets_total", "counter", "Datagrams relayed, by direction.")
+	writeLabeled(b, "peel_packets_total", "direction", m.packets)
+	writeHelp(b, "peel_bytes_total", "counter", "Payload bytes relayed, by direction.")
+	writeLabeled(b, "peel_bytes_total", "direction", m.bytes)
+
+	writeHelp(b, "peel_sessions", "gauge", "Active player sessions.")
+	fmt.Fprintf(b, "peel_sessions %d\n", len(r.sessions))
+	writeHelp(b, "peel_sessions_created_total", "counter", "Sessions opened.")
+	fmt.Fprintf(b, "peel_sessions_created_total %d\n", m.sessionsCreated)
+	writeHelp(b, "peel_sessions_closed_total", "counter", "Sessions closed, by reason.")
+	writeLabeled(b, "peel_sessions_closed_total", "reason", m.sessionsClosed)
+
+	writeHelp(b, "peel_routes", "gauge", "Entries in the route table.")
+	fmt.Fprintf(b, "peel_routes %d\n", len(r.router.routes))
+	writeHelp(b, "peel_negative_cache_entries", "gauge", "IPs currently negative-cached.")
+	fmt.Fprintf(b, "peel_negative_cache_entries %d\n", r.negativeCache.Active(time.Now().UnixNano()))
+	writeHelp(b, "peel_negative_cache_hits_total", "counter", "Packets dropped by the negative cache.")
+	fmt.Fprintf(b, "peel_negative_cache_hits_total %d\n", m.negativeCacheHits)
+
+	writeHelp(b, "peel_ratelimit_dropped_total", "counter", "Inbound packets dropped by flood protection, by reason.")
+	writeLabeled(b, "peel_ratelimit_dropped_total", "reason", r.limiter.dropped)
+	writeHelp(b, "peel_flood_blocks_total", "counter", "Source IPs blocked for flooding.")
+	fmt.Fprintf(b, "peel_flood_blocks_total %d\n", r.limiter.blocks)
+	writeHelp(b, "peel_access_dropped_total", "counter", "Inbound packets dropped by the blocklist or allowlist.")
+	writeLabeled(b, "peel_access_dropped_total", "list", m.accessDropped)
+	writeHelp(b, "peel_blocklist_entries", "gauge", "Entries on the blocklist.")
+	fmt.Fprintf(b, "peel_blocklist_entries %d\n", len(r.blocklist.entries))
+	writeHelp(b, "peel_allowlist_entries", "gauge", "Entries on the allowlist.")
+	fmt.Fprintf(b, "peel_allowlist_entries %d\n", len(r.allowlist.entries))
+
+	writeHelp(b, "peel_audit_entries_total", "counter", "Control-API changes recorded in the audit log.")
+	fmt.Fprintf(b, "peel_audit_entries_total %d\n", r.audit.lastID)
+	writeHelp(b, "peel_audit_file_errors_total", "counter", "Audit entries that could not be appended to audit_file.")
+	fmt.Fprintf(b, "peel_audit_file_errors_total %d\n", r.audit.fileErrors)
+
+	writeHelp(b, "peel_pending_lookups", "gauge", "Route lookups in flight with queued packets.")
+	fmt.Fprintf(b, "peel_pending_lookups %d\n", len(r.pending))
+	writeHelp(b, "peel_pending_dropped_total", "counter", "Queued packets dropped (queue full, lookup failed or expired).")
+	fmt.Fprintf(b, "peel_pending_dropped_total %d\n", r.pendingDropped)
+
+	writeHelp(b, "peel_route_requests_total", "counter", "Bananasplit route requests completed.")
+	fmt.Fprintf(b, "peel_route_requests_total %d\n", m.routeRequests)
+	writeHelp(b, "peel_route_request_errors_total", "counter", "Failed Bananasplit route requests, by error class.")
+	writeLabeled(b, "peel_route_request_errors_total", "class", m.routeErrors)
+	writeHelp(b, "peel_route_request_duration_seconds", "histogram", "Bananasplit route request latency.")
+	writeHistogram(b, "peel_route_request_duration_seconds", m.routeLatency)
+
+	writeHelp(b, "peel_bananasplit_endpoint_up", "gauge", "1 while a Bananasplit URL is in rotation, by url.")
+	for _, e := range r.bananasplit.endpoints {
+		fmt.Fprintf(b, "peel_bananasplit_endpoint_up{url=%q} %d\n", e.URL, boolGauge(e.failures == 0))
+	}
+	writeHelp(b, "peel_bananasplit_endpoint_requests_total", "counter", "Requests sent to each Bananasplit URL.")
+	for _, e := range r.bananasplit.endpoints {
+		fmt.Fprintf(b, "peel_bananasplit_endpoint_requests_total{url=%q} %d\n", e.URL, e.requests)
+	}
+	writeHelp(b, "peel_bananasplit_endpoint_errors_total", "counter", "Transport errors, timeouts and 5xx from each Bananasplit URL.")
+	for _, e := range r.bananasplit.endpoints {
+		fmt.Fprintf(b, "peel_bananasplit_endpoint_errors_total{url=%q} %d\n", e.URL, e.errors)
+	}
+	writeHelp(b, "peel_bananasplit_endpoint_duration_seconds", "histogram", "Route request latency per Bananasplit URL.")
+	for _, e := range r.bananasplit.endpoints {
+		writeHistogramLabeled(b, "peel_bananasplit_endpoint_duration_seconds", fmt.Sprintf("url=%q", e.URL), e.latency)
+	}
+
+	writeHelp(b, "peel_bananasplit_circuit_open", "gauge", "1 while the Bananasplit circuit breaker is open or half-open.")
+	fmt.Fprintf(b, "peel_bananasplit_circuit_open %d\n", boolGauge(r.breaker.state != breakerClosed))
+	writeHelp(b, "peel_bananasplit_circuit_trips_total", "counter", "Times the Bananasplit circuit breaker opened.")
+	fmt.Fprintf(b, "peel_bananasplit_circuit_trips_total %d\n", r.breaker.trips)
+	writeHelp(b, "peel_bananasplit_circuit_rejected_total", "counter", "Route lookups failed fast by the open circuit breaker.")
+	fmt.Fprintf(b, "peel_bananasplit_circuit_rejected_total %d\n", r.breaker.rejected)
+
+	writeHelp(b, "peel_idle_sweeps_total", "counter", "Idle-session sweeps run.")
+	fmt.Fprintf(b, "peel_idle_sweeps_total %d\n", m.idleSweeps)
+
+	writeHelp(b, "peel_route_syncs_total", "counter", "Completed reconciliation syncs with Bananasplit.")
+	fmt.Fprintf(b, "peel_route_syncs_total %d\n", m.routeSyncs)
+	writeHelp(b, "peel_route_sync_errors_total", "counter", "Failed reconciliation syncs.")
+	fmt.Fprintf(b, "peel_route_sync_errors_total %d\n", m.routeSyncErrors)
+	writeHelp(b, "peel_route_drift_total", "counter", "Routes corrected by reconciliation, by kind.")
+	writeLabeled(b, "peel_route_drift_total", "kind", m.routeDrift)
+
+	writeHelp(b, "peel_route_watch_up", "gauge", "1 while the Bananasplit route watch stream is healthy.")
+	fmt.Fprintf(b, "peel_route_watch_up %d\n", boolGauge(m.watchUp))
+	writeHelp(b, "peel_route_watch_changes_total", "counter", "Route changes received from the watch stream.")
+	fmt.Fprintf(b, "peel_route_watch_changes_total %d\n", m.watchChanges)
+	writeHelp(b, "peel_route_watch_errors_total", "counter", "Failed watch polls.")
+	fmt.Fprintf(b, "peel_route_watch_errors_total %d\n", m.watchErrors)
+
+	writeHelp(b, "peel_backends", "gauge", "Backends tracked by health checking.")
+	fmt.Fprintf(b, "peel_backends %d\n", len(r.health.backends))
+	writeHelp(b, "peel_backends_unhealthy", "gauge", "Tracked backends currently marked unhealthy.")
+	fmt.Fprintf(b, "peel_backends_unhealthy %d\n", r.health.unhealthyCount())
+
+	writeHelp(b, "peel_webhook_events_sent_total", "counter", "Events delivered by webhook.")
+	fmt.Fprintf(b, "peel_webhook_events_sent_total %d\n", m.webhookSent)
+	writeHelp(b, "peel_webhook_errors_total", "counter", "Failed webhook delivery attempts.")
+	fmt.Fprintf(b, "peel_webhook_errors_total %d\n", m.webhookErrors)
+	writeHelp(b, "peel_webhook_events_dropped_total", "counter", "Webhook events dropped (outbox full or retries exhausted).")
+	fmt.Fprintf(b, "peel_webhook_events_dropped_total %d\n", m.webhookDropped)
+}
+
+func boolGauge(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func writeHelp(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeLabeled writes one sample per map entry, sorted by label value
+// so scrapes are stable.
+func writeLabeled(b *strings.Builder, name, label string, values map[string]uint64) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
+
+func writeHistogram(b *strings.Builder, name string, h *histogram) {
+	writeHistogramLabeled(b, name, "", h)
+}
+
+// writeHistogramLabeled writes h with extra labels (`k="v"`, or "" for
+// none) on every series.
+func writeHistogramLabeled(b *strings.Builder, name, labels string, h *histogram) {
+	sel, sep := "", ""
+	if labels != "" {
+		sel, sep = "{"+labels+"}", labels+","
+	}
+	var cum uint64
+	for i, le := range h.bounds {
+		cum += h.counts[i]
+		fmt.Fprintf(b, "%s_bucket{%sle=\"%g\"} %d\n", name, sep, le, cum)
+	}
+	fmt.Fprintf(b, "%s_bucket{%sle=\"+Inf\"} %d\n", name, sep, h.count)
+	fmt.Fprintf(b, "%s_sum%s %g\n", name, sel, h.sum)
+	fmt.Fprintf(b, "%s_count%s %d\n", name, sel, h.count)
+}
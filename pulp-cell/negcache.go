@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// negativeEntry is the negative-cache state of one player IP whose
+// route lookup failed.
+type negativeEntry struct {
+	Expires   int64 // wall-time nanoseconds; packets are dropped until then
+	Failures  int   // consecutive failed lookups
+	LastError string
+}
+
+// negativeCache remembers player IPs whose Bananasplit lookup recently
+// failed, so junk packets from them are dropped without another HTTP
+// call. Each consecutive failure doubles the window, from ttl up to
+// maxTTL. An entry is kept for maxTTL past its expiry so a returning
+// failure still backs off, then evicted by Sweep; a successful lookup
+// clears it.
+type negativeCache struct {
+	ttl       time.Duration
+	maxTTL    time.Duration
+	entries   map[string]*negativeEntry
+	nextSweep int64 // wall-time nanoseconds
+}
+
+func newNegativeCache(ttl, maxTTL time.Duration) *negativeCache {
+	return &negativeCache{
+		ttl:     ttl,
+		maxTTL:  maxTTL,
+		entries: make(map[string]*negativeEntry),
+	}
+}
+
+// Blocked reports whether packets from ip should be dropped at now.
+func (c *negativeCache) Blocked(ip string, now int64) bool {
+	e, ok := c.entries[ip]
+	return ok && now < e.Expires
+}
+
+// Fail records a failed lookup for ip and returns the new window.
+func (c *negativeCache) Fail(ip string, err error, now int64) time.Duration {
+	e, ok := c.entries[ip]
+	if !ok {
+		e = &negativeEntry{}
+		c.entries[ip] = e
+	}
+	e.Failures++
+	e.LastError = err.Error()
+	window := c.ttl
+	for i := 1; i < e.Failures && window < c.maxTTL; i++ {
+		window *= 2
+	}
+	window = min(window, c.maxTTL)
+	e.Expires = now + int64(window)
+	return window
+}
+
+// Clear forgets ip.
+func (c *negativeCache) Clear(ip string) bool {
+	_, ok := c.entries[ip]
+	delete(c.entries, ip)
+	return ok
+}
+
+// Purge forgets every entry and returns how many there were.
+func (c *negativeCache) Purge() int {
+	n := len(c.entries)
+	c.entries = make(map[string]*negativeEntry)
+	return n
+}
+
+// Sweep evicts entries that expired more than maxTTL ago. It does the
+// scan at most once per ttl.
+func (c *negativeCache) Sweep(now int64) {
+	if now < c.nextSweep {
+		return
+	}
+	c.nextSweep = now + int64(max(c.ttl, time.Second))
+	for ip, e := range c.entries {
+		if now > e.Expires+int64(c.maxTTL) {
+			delete(c.entries, ip)
+		}
+	}
+}
+
+// Active returns how many IPs are currently being dropped.
+func (c *negativeCache) Active(now int64) int {
+	n := 0
+	for _, e := range c.entries {
+		if now < e.Expires {
+			n++
+		}
+	}
+	return n
+}
+
+// negativeInfo is one entry of GET /negative-cache.
+type negativeInfo struct {
+	PlayerIP  string `json:"player_ip"`
+	ExpiresIn int64  `json:"expires_in"` // seconds; 0 once expired
+	Failures  int    `json:"failures"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// List returns every entry sorted by IP, including expired ones still
+// remembered for backoff.
+func (c *negativeCache) List(now int64) []negativeInfo {
+	out := make([]negativeInfo, 0, len(c.entries))
+	for ip, e := range c.entries {
+		info := negativeInfo{PlayerIP: ip, Failures: e.Failures, LastError: e.LastError}
+		if e.Expires > now {
+			info.ExpiresIn = (e.Expires - now) / int64(time.Second)
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PlayerIP < out[j].PlayerIP })
+	return out
+}
+
+// SweepNegativeCache runs once per step and evicts stale entries.
+func (r *Relay) SweepNegativeCache(wallNanos uint64) {
+	r.negativeCache.Sweep(int64(wallNanos))
+}
+
+// PurgeNegativeCache forgets key ("" for every IP) so its next packet
+// asks Bananasplit again. Returns how many entries were removed.
+func (r *Relay) PurgeNegativeCache(key string) int {
+	n := 0
+	if key == "" {
+		n = r.negativeCache.Purge()
+	} else if r.negativeCache.Clear(key) {
+		n = 1
+	}
+	if n > 0 {
+		if key == "" {
+			key = "all"
+		}
+		log.Printf("Negative cache purged: %s (%d entries)", key, n)
+	}
+	return n
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/BananaLabs-OSS/Fiber/pulp/udp"
+)
+
+// pendingPacket is a player datagram held while its route resolves.
+type pendingPacket struct {
+	flow       string
+	payload    []byte
+	receivedAt int64
+}
+
+// pendingRoute is the in-flight Bananasplit lookup for one IP plus the
+// packets that arrived for it in the meantime.
+type pendingRoute struct {
+	packets  []pendingPacket
+	deadline uint64 // wall-time nanoseconds
+}
+
+// enqueuePending queues pkt behind the route lookup for playerIP,
+// starting the lookup if none is in flight. Packets beyond the per-IP
+// limit are dropped and counted.
+func (r *Relay) enqueuePending(playerIP string, pkt udp.Packet) {
+	p, inFlight := r.pending[playerIP]
+	if !inFlight {
+		p = &pendingRoute{deadline: uint64(pkt.ReceivedAt) + uint64(r.pendingTimeout)}
+		r.pending[playerIP] = p
+	}
+	if len(p.packets) >= r.pendingLimit {
+		r.pendingDropped++
+	} else {
+		// The host may reuse the receive buffer once the callback
+		// returns, so keep a private copy.
+		p.packets = append(p.packets, pendingPacket{
+			flow:       pkt.SrcAddr,
+			payload:    append([]byte(nil), pkt.Payload...),
+			receivedAt: pkt.ReceivedAt,
+		})
+	}
+	if inFlight {
+		return
+	}
+
+	// Bananasplit assigns per IP, so the learned route is stored at IP
+	// level and covers every flow from that address.
+	r.requestRoute(playerIP, func(backend string, err error) {
+		r.resolvePending(playerIP, p, backend, err)
+	})
+}
+
+// resolvePending applies a finished lookup: on success the route is
+// stored and the queued packets are flushed to their backends; on
+// failure the queue is dropped and, unless the breaker short-circuited
+// the lookup, the IP is negative-cached.
+func (r *Relay) resolvePending(playerIP string, p *pendingRoute, backend string, err error) {
+	// The queue may already have expired (and a newer lookup begun);
+	// only flush the queue this lookup was started for.
+	queued := r.pending[playerIP] == p
+	if queued {
+		delete(r.pending, playerIP)
+	}
+
+	if err != nil {
+		if queued {
+			r.pendingDropped += uint64(len(p.packets))
+		}
+		// A breaker short-circuit never reached Bananasplit, so it says
+		// nothing about this IP: drop the queue but leave its backoff
+		// alone, and let the next packet ask again once the breaker
+		// lets lookups through. It is still counted in
+		// peel_route_request_errors_total{class="circuit_open"}.
+		if routeErrorClass(err) == "circuit_open" {
+			return
+		}
+		log.Printf("Failed to get route for %s: %v", playerIP, err)
+		r.emit(relayEvent{Type: evRouteRequestFailed, PlayerIP: playerIP, Error: err.Error()})
+		// Cache the failure so the IP's packets are dropped without
+		// another lookup; repeated failures back off exponentially.
+		window := r.negativeCache.Fail(playerIP, err, time.Now().UnixNano())
+		r.emit(relayEvent{Type: evNegativeCacheInsert, PlayerIP: playerIP, Error: err.Error()})
+		log.Printf("Negative-caching %s for %s", playerIP, window)
+		return
+	}
+	// Clear any stale negative cache entry on success.
+	r.negativeCache.Clear(playerIP)
+	// A route pushed via the control API while the lookup was in flight
+	// is authoritative; don't overwrite it with Bananasplit's answer.
+	if _, ok := r.router.Get(playerIP); !ok {
+		r.router.SetExpiring(playerIP, backend, expiryAfter(r.routeTTL))
+		r.emit(relayEvent{Type: evRouteSet, PlayerIP: playerIP, Backend: backend, Reason: "bananasplit"})
+	}
+	if !queued {
+		return
+	}
+	for _, pkt := range p.packets {
+		if b, ok := r.router.Lookup(pkt.flow); ok {
+			r.forward(pkt.flow, b, pkt.payload, pkt.receivedAt)
+		}
+	}
+}
+
+// SweepPending runs once per step. Drops the queues of lookups that
+// have not resolved within pendingTimeout. The lookup itself still
+// completes and stores its route; only the held packets are given up.
+func (r *Relay) SweepPending(wallNanos uint64) {
+	for ip, p := range r.pending {
+		if wallNanos > p.deadline {
+			r.pendingDropped += uint64(len(p.packets))
+			delete(r.pending, ip)
+		}
+	}
+}
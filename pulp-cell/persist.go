@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// stateSnapshot is the on-disk form of the relay state that survives a
+// restart of the pulp host.
+type stateSnapshot struct {
+	SavedAt       int64                    `json:"saved_at"` // wall-time nanoseconds
+	Routes        map[string]snapshotRoute `json:"routes"`
+	NegativeCache map[string]int64         `json:"negative_cache,omitempty"`
+
+	// NegativeFailures carries the consecutive-failure count behind each
+	// NegativeCache entry so backoff resumes where it left off.
+	NegativeFailures map[string]int `json:"negative_failures,omitempty"`
+
+	Blocklist []accessEntry `json:"blocklist,omitempty"`
+	Allowlist []accessEntry `json:"allowlist,omitempty"`
+
+	// Pools carries every pool, including those created or changed
+	// through POST /pools, so restored "pool:<name>" routes still
+	// resolve.
+	Pools []backendPool `json:"pools,omitempty"`
+}
+
+type snapshotRoute struct {
+	Backend string `json:"backend"`
+	Expires uint64 `json:"expires,omitempty"`
+	Proxy   string `json:"proxy_protocol,omitempty"`
+}
+
+// persister snapshots the route table, negative cache, access lists
+// and pools to a file every interval and on shutdown, and restores
+// them at boot. Disabled when path is empty.
+type persister struct {
+	relay    *Relay
+	path     string
+	interval time.Duration
+	maxAge   time.Duration
+	last     uint64 // wall-time nanoseconds of the last save
+}
+
+func newPersister(relay *Relay, cfg appConfig) *persister {
+	return &persister{
+		relay:    relay,
+		path:     cfg.StateFile,
+		interval: cfg.SnapshotInterval,
+		maxAge:   cfg.SnapshotMaxAge,
+	}
+}
+
+// Restore loads the snapshot into the relay. A missing file is not an
+// error (first boot); a snapshot older than maxAge is ignored so a long
+// outage doesn't resurrect routes Bananasplit has since moved. Routes
+// whose TTL ran out while the cell was down are dropped.
+func (p *persister) Restore() error {
+	if p.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read state: %w", err)
+	}
+	var snap stateSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decode state: %w", err)
+	}
+
+	now := time.Now().UnixNano()
+
+	// Access lists are operator policy, not cached routing state, so
+	// they are restored however old the snapshot is.
+	for _, e := range snap.Blocklist {
+		if e.Expires == 0 || e.Expires > uint64(now) {
+			p.relay.blocklist.Set(e)
+		}
+	}
+	for _, e := range snap.Allowlist {
+		if e.Expires == 0 || e.Expires > uint64(now) {
+			p.relay.allowlist.Set(e)
+		}
+	}
+	// So are pools, which also have to be back before the routes that
+	// target them are checked. A saved pool replaces the pulp.cell.toml
+	// pool of the same name, since it reflects the latest API write.
+	for _, pool := range snap.Pools {
+		if msg := pool.normalize(p.relay.dest); msg != "" {
+			log.Printf("State snapshot: dropping pool %s: %s", pool.Name, msg)
+			continue
+		}
+		p.relay.SetPool(pool)
+	}
+
+	age := time.Duration(now - snap.SavedAt)
+	if p.maxAge > 0 && age > p.maxAge {
+		log.Printf("State snapshot %s is %s old (limit %s); starting empty", p.path, age.Round(time.Second), p.maxAge)
+		return nil
+	}
+
+	restored := 0
+	for key, sr := range snap.Routes {
+		if sr.Expires != 0 && sr.Expires <= uint64(now) {
+			continue
+		}
+		// The destination policy may have tightened since the save.
+		if err := p.relay.checkTarget(sr.Backend); err != nil {
+			log.Printf("State snapshot: dropping route %s → %s: %v", key, sr.Backend, err)
+			continue
+		}
+		p.relay.router.SetEntry(key, routeEntry{Backend: sr.Backend, Expires: sr.Expires, Proxy: sr.Proxy})
+		restored++
+	}
+	// Expired entries are still restored while they count toward
+	// backoff; Sweep evicts them later.
+	for ip, exp := range snap.NegativeCache {
+		if exp+int64(p.relay.negativeCache.maxTTL) > now {
+			p.relay.negativeCache.entries[ip] = &negativeEntry{
+				Expires:  exp,
+				Failures: max(snap.NegativeFailures[ip], 1),
+			}
+		}
+	}
+	log.Printf("Restored %d routes from %s", restored, p.path)
+	return nil
+}
+
+// Save writes the current state. The file is replaced via rename so a
+// crash mid-write never leaves a truncated snapshot behind.
+func (p *persister) Save() error {
+	if p.path == "" {
+		return nil
+	}
+	snap := stateSnapshot{
+		SavedAt:          time.Now().UnixNano(),
+		Routes:           make(map[string]snapshotRoute),
+		NegativeCache:    make(map[string]int64, len(p.relay.negativeCache.entries)),
+		NegativeFailures: make(map[string]int, len(p.relay.negativeCache.entries)),
+		Blocklist:        p.relay.blocklist.List(),
+		Allowlist:        p.relay.allowlist.List(),
+		Pools:            p.relay.Pools(),
+	}
+	for key, e := range p.relay.router.Entries() {
+		snap.Routes[key] = snapshotRoute{Backend: e.Backend, Expires: e.Expires, Proxy: e.Proxy}
+	}
+	for ip, e := range p.relay.negativeCache.entries {
+		snap.NegativeCache[ip] = e.Expires
+		snap.NegativeFailures[ip] = e.Failures
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp, p.path); err != nil {
+		return fmt.Errorf("replace state: %w", err)
+	}
+	return nil
+}
+
+// Step runs once per step and saves when the interval has elapsed.
+func (p *persister) Step(wallNanos uint64) {
+	if p.path == "" || p.interval <= 0 {
+		return
+	}
+	if p.last == 0 {
+		p.last = wallNanos
+		return
+	}
+	if wallNanos < p.last+uint64(p.interval) {
+		return
+	}
+	p.last = wallNanos
+	if err := p.Save(); err != nil {
+		log.Printf("State snapshot failed: %v", err)
+	}
+}
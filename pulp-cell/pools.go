@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+)
+
+// poolPrefix marks a route backend that names a pool ("pool:lobby")
+// instead of a single host:port.
+const poolPrefix = "pool:"
+
+// Pool selection policies, applied once per new session.
+//
+//   - "weighted_random": pick a member with probability proportional
+//     to its weight.
+//   - "least_sessions": pick the member with the fewest live sessions
+//     relative to its weight.
+//   - "consistent_hash": weighted rendezvous hash of the player IP, so
+//     a returning player lands on the same member while the pool is
+//     unchanged, and only ~1/n of players move when a member is added
+//     or removed.
+const (
+	policyWeightedRandom = "weighted_random"
+	policyLeastSessions  = "least_sessions"
+	policyConsistentHash = "consistent_hash"
+)
+
+// poolMember is one backend of a pool.
+type poolMember struct {
+	Addr   string `json:"addr"`
+	Weight int    `json:"weight"`
+}
+
+// backendPool is a named, weighted set of backends that routes can
+// point at.
+type backendPool struct {
+	Name     string       `json:"name"`
+	Policy   string       `json:"policy"`
+	Backends []poolMember `json:"backends"`
+}
+
+// normalize fills defaults and checks the pool against the backend
+// destination policy, returning a non-empty error message when it is
+// invalid.
+func (p *backendPool) normalize(dest *destPolicy) string {
+	if p.Name == "" || strings.ContainsAny(p.Name, ":/ ") {
+		return "invalid pool name"
+	}
+	if p.Policy == "" {
+		p.Policy = policyWeightedRandom
+	}
+	switch p.Policy {
+	case policyWeightedRandom, policyLeastSessions, policyConsistentHash:
+	default:
+		return "invalid pool policy"
+	}
+	if len(p.Backends) == 0 {
+		return "pool needs at least one backend"
+	}
+	for i := range p.Backends {
+		m := &p.Backends[i]
+		if err := dest.Check(m.Addr); err != nil {
+			return err.Error()
+		}
+		if m.Weight == 0 {
+			m.Weight = 1
+		}
+		if m.Weight < 0 {
+			return "invalid backend weight"
+		}
+	}
+	return ""
+}
+
+// poolName returns the pool a route backend refers to, if any.
+func poolName(backend string) (string, bool) {
+	return strings.CutPrefix(backend, poolPrefix)
+}
+
+// checkTarget returns why backend can't be stored as a route, or nil
+// when it can: a host:port the destination policy allows, or a
+// reference to a configured pool (whose members were checked when it
+// was set).
+func (r *Relay) checkTarget(backend string) error {
+	if name, ok := poolName(backend); ok {
+		if _, exists := r.pools[name]; !exists {
+			return fmt.Errorf("unknown pool %s", name)
+		}
+		return nil
+	}
+	return r.dest.Check(backend)
+}
+
+// resolveBackend turns a route target into the concrete backend a
+// session for flow should use: a pool is narrowed to one member by its
+// policy, and an unhealthy backend is replaced by the fallback.
+func (r *Relay) resolveBackend(target, flow string) string {
+	if name, ok := poolName(target); ok {
+		p, exists := r.pools[name]
+		if !exists {
+			return target
+		}
+		target = r.pickMember(p, hostOf(flow))
+	}
+	return r.health.resolve(target)
+}
+
+// pickMember applies p's policy. Unhealthy members are skipped while at
+// least one healthy member remains.
+func (r *Relay) pickMember(p *backendPool, playerIP string) string {
+	members := make([]poolMember, 0, len(p.Backends))
+	for _, m := range p.Backends {
+		if m.Weight > 0 && r.health.healthy(m.Addr) {
+			members = append(members, m)
+		}
+	}
+	if len(members) == 0 {
+		members = p.Backends
+	}
+
+	switch p.Policy {
+	case policyLeastSessions:
+		load := make(map[string]int)
+		for _, sess := range r.sessions {
+			load[sess.Backend]++
+		}
+		best, bestScore := members[0].Addr, math.Inf(1)
+		for _, m := range members {
+			score := float64(load[m.Addr]) / float64(max(m.Weight, 1))
+			if score < bestScore {
+				best, bestScore = m.Addr, score
+			}
+		}
+		return best
+	case policyConsistentHash:
+		best, bestScore := members[0].Addr, math.Inf(-1)
+		for _, m := range members {
+			h := fnv.New64a()
+			h.Write([]byte(playerIP))
+			h.Write([]byte{0})
+			h.Write([]byte(m.Addr))
+			// Map the hash to (0,1) and weight it: -w/ln(u) is the
+			// standard weighted rendezvous score.
+			u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+			score := -float64(max(m.Weight, 1)) / math.Log(u)
+			if score > bestScore {
+				best, bestScore = m.Addr, score
+			}
+		}
+		return best
+	default: // weighted_random
+		total := 0
+		for _, m := range members {
+			total += max(m.Weight, 1)
+		}
+		n := rand.IntN(total)
+		for _, m := range members {
+			if n -= max(m.Weight, 1); n < 0 {
+				return m.Addr
+			}
+		}
+		return members[len(members)-1].Addr
+	}
+}
+
+// SetPool creates or replaces a pool. Existing sessions keep their
+// member; only new sessions see the change.
+func (r *Relay) SetPool(p backendPool) {
+	r.pools[p.Name] = &p
+}
+
+// errPoolNotFound is returned by DeletePool for an unknown pool.
+var errPoolNotFound = errors.New("pool not found")
+
+// DeletePool removes a pool unless a route still points at it.
+func (r *Relay) DeletePool(name string) error {
+	if _, ok := r.pools[name]; !ok {
+		return errPoolNotFound
+	}
+	for key, e := range r.router.Entries() {
+		if e.Backend == poolPrefix+name {
+			return fmt.Errorf("pool %s in use by route %s", name, key)
+		}
+	}
+	delete(r.pools, name)
+	return nil
+}
+
+// Pools returns every pool sorted by name.
+func (r *Relay) Pools() []backendPool {
+	out := make([]backendPool, 0, len(r.pools))
+	for _, p := range r.pools {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDeletePool(t *testing.T) {
+	r := newTestRelay(t)
+	r.SetPool(backendPool{Name: "lobby", Backends: []poolMember{{Addr: "10.0.50.2:5521", Weight: 1}}})
+	r.router.Set("203.0.113.50", poolPrefix+"lobby")
+
+	if err := r.DeletePool("arena"); !errors.Is(err, errPoolNotFound) {
+		t.Errorf("DeletePool(unknown) = %v, want errPoolNotFound", err)
+	}
+	if err := r.DeletePool("lobby"); err == nil || errors.Is(err, errPoolNotFound) {
+		t.Errorf("DeletePool(in use) = %v, want an in-use error", err)
+	}
+	r.router.Delete("203.0.113.50")
+	if err := r.DeletePool("lobby"); err != nil {
+		t.Errorf("DeletePool(unused) = %v", err)
+	}
+	if len(r.Pools()) != 0 {
+		t.Errorf("pools left: %v", r.Pools())
+	}
+	if err := r.DeletePool("lobby"); !errors.Is(err, errPoolNotFound) {
+		t.Errorf("second DeletePool = %v, want errPoolNotFound", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"strconv"
+)
+
+// PROXY protocol v2 modes for traffic toward a backend.
+//
+//   - proxyOff: payloads are forwarded untouched (native behavior).
+//   - proxyHeader: every datagram is prefixed with a PROXY v2 header,
+//     the HAProxy convention for UDP.
+//   - proxyPreamble: the header is sent once, as its own datagram,
+//     before the first payload of a session (and again after a
+//     hot-swap), for backends that track the client per flow.
+const (
+	proxyOff      = "off"
+	proxyHeader   = "header"
+	proxyPreamble = "preamble"
+)
+
+// validProxyMode reports whether mode is a known PROXY protocol mode.
+// The empty string is valid on routes and means "use the global mode".
+func validProxyMode(mode string) bool {
+	switch mode {
+	case "", proxyOff, proxyHeader, proxyPreamble:
+		return true
+	}
+	return false
+}
+
+// proxyV2Signature is the fixed 12-byte PROXY protocol v2 prefix.
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// buildProxyV2Header returns the PROXY v2 header announcing src (the
+// player's "ip:port") as the client of a datagram (DGRAM) connection to
+// dstPort on the relay. The relay listens on a wildcard address, so the
+// destination IP is the unspecified address of src's family. Returns
+// nil when src is not a literal ip:port.
+func buildProxyV2Header(src string, dstPort uint16) []byte {
+	ap, err := netip.ParseAddrPort(src)
+	if err != nil {
+		return nil
+	}
+	ip := ap.Addr().Unmap()
+
+	hdr := append([]byte(nil), proxyV2Signature...)
+	hdr = append(hdr, 0x21) // version 2, PROXY command
+	if ip.Is4() {
+		hdr = append(hdr, 0x12) // AF_INET, SOCK_DGRAM
+		hdr = binary.BigEndian.AppendUint16(hdr, 12)
+		src4 := ip.As4()
+		hdr = append(hdr, src4[:]...)
+		hdr = append(hdr, 0, 0, 0, 0)
+	} else {
+		hdr = append(hdr, 0x22) // AF_INET6, SOCK_DGRAM
+		hdr = binary.BigEndian.AppendUint16(hdr, 36)
+		src16 := ip.As16()
+		hdr = append(hdr, src16[:]...)
+		hdr = append(hdr, make([]byte, 16)...)
+	}
+	hdr = binary.BigEndian.AppendUint16(hdr, ap.Port())
+	hdr = binary.BigEndian.AppendUint16(hdr, dstPort)
+	return hdr
+}
+
+// listenPort extracts the numeric port from a listen address such as
+// ":5520" or "0.0.0.0:5520". Returns 0 when there is none.
+func listenPort(addr string) uint16 {
+	for i := len(addr) - 1; i >= 0; i-- {
+		if addr[i] == ':' {
+			n, err := strconv.ParseUint(addr[i+1:], 10, 16)
+			if err != nil {
+				return 0
+			}
+			return uint16(n)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The header bytes are a wire contract with every backend that parses
+// PROXY v2, so they are spelled out in full rather than rebuilt.
+func TestBuildProxyV2Header(t *testing.T) {
+	sig := []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+	join := func(parts ...[]byte) []byte { return bytes.Join(append([][]byte{sig}, parts...), nil) }
+
+	v4 := join(
+		[]byte{0x21, 0x12, 0x00, 0x0C}, // v2 PROXY, AF_INET DGRAM, 12 bytes
+		[]byte{203, 0, 113, 50},        // source 203.0.113.50
+		[]byte{0, 0, 0, 0},             // destination 0.0.0.0
+		[]byte{0x9C, 0x40},             // source port 40000
+		[]byte{0x15, 0x90},             // destination port 5520
+	)
+	v6 := join(
+		[]byte{0x21, 0x22, 0x00, 0x24}, // v2 PROXY, AF_INET6 DGRAM, 36 bytes
+		// source 2001:db8::1
+		[]byte{0x20, 0x01, 0x0D, 0xB8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01},
+		make([]byte, 16),   // destination ::
+		[]byte{0x9C, 0x40}, // source port 40000
+		[]byte{0x15, 0x90}, // destination port 5520
+	)
+
+	tests := []struct {
+		name string
+		src  string
+		want []byte
+	}{
+		{"ipv4", "203.0.113.50:40000", v4},
+		{"ipv4-mapped ipv6", "[::ffff:203.0.113.50]:40000", v4},
+		{"ipv6", "[2001:db8::1]:40000", v6},
+		{"no port", "203.0.113.50", nil},
+		{"hostname", "player.example:40000", nil},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildProxyV2Header(tt.src, 5520)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("buildProxyV2Header(%q, 5520) =\n% x\nwant\n% x", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenPort(t *testing.T) {
+	tests := []struct {
+		addr string
+		want uint16
+	}{
+		{":5520", 5520},
+		{"0.0.0.0:5520", 5520},
+		{"[::]:5521", 5521},
+		{"5520", 0},
+		{":", 0},
+		{":70000", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := listenPort(tt.addr); got != tt.want {
+			t.Errorf("listenPort(%q) = %d, want %d", tt.addr, got, tt.want)
+		}
+	}
+}
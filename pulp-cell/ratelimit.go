@@ -0,0 +1,165 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Rate-limit drop reasons, used as the "reason" label on
+// peel_ratelimit_dropped_total.
+const (
+	dropIPRate   = "ip_rate"
+	dropOverflow = "ip_overflow"
+	dropLookup   = "lookup_rate"
+)
+
+// maxTrackedIPs bounds the per-IP bucket map. A spoofed-source flood
+// would otherwise add an entry per forged address faster than Sweep
+// forgets them.
+const maxTrackedIPs = 65536
+
+// floodWindow is how long a source IP's rate-limit drops are counted
+// toward flood_block_after before the count starts over.
+const floodWindow = 10 * time.Second
+
+// tokenBucket is a classic token bucket refilled continuously at rate
+// tokens per second up to burst.
+type tokenBucket struct {
+	tokens float64
+	last   int64 // wall-time nanoseconds of the last refill
+}
+
+// take refills b to now and spends one token if there is one.
+func (b *tokenBucket) take(now int64, rate, burst float64) bool {
+	if b.last == 0 {
+		b.tokens = burst
+	} else if now > b.last {
+		b.tokens = min(burst, b.tokens+float64(now-b.last)/float64(time.Second)*rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// ipLimit is the per-source-IP limiter state.
+type ipLimit struct {
+	bucket      tokenBucket
+	drops       int
+	windowStart int64 // wall-time nanoseconds
+}
+
+// rateLimiter protects the inbound path against floods: a token bucket
+// per source IP caps its packet rate, and a global bucket caps how many
+// new-IP Bananasplit lookups start per second, so a spoofed-source
+// flood can't fan out into the control plane. An IP that keeps
+// exceeding its limit is reported so the relay can put it on the
+// blocklist for blockFor. Once maxTrackedIPs are tracked, packets from
+// further IPs share the overflow bucket (at the per-IP rate) and are
+// never flood-blocked, since they have no state of their own.
+type rateLimiter struct {
+	ipRate      float64 // packets/s per source IP; 0 disables
+	ipBurst     float64
+	lookupRate  float64 // new lookups/s across all IPs; 0 disables
+	lookupBurst float64
+	blockAfter  int // drops within floodWindow that trigger a block; 0 never blocks
+	blockFor    time.Duration
+
+	ips       map[string]*ipLimit
+	overflow  tokenBucket
+	lookups   tokenBucket
+	nextSweep int64
+
+	dropped map[string]uint64 // by reason
+	blocks  uint64
+}
+
+func newRateLimiter(cfg appConfig) *rateLimiter {
+	return &rateLimiter{
+		ipRate:      cfg.RateLimitPPS,
+		ipBurst:     float64(cfg.RateLimitBurst),
+		lookupRate:  cfg.LookupRate,
+		lookupBurst: float64(cfg.LookupBurst),
+		blockAfter:  cfg.FloodBlockAfter,
+		blockFor:    cfg.FloodBlockFor,
+		ips:         make(map[string]*ipLimit),
+		dropped:     make(map[string]uint64),
+	}
+}
+
+// AllowPacket reports whether a datagram from ip received at now may
+// be processed, counting drops. block is set once ip has crossed the
+// flood threshold and should be blocked.
+func (l *rateLimiter) AllowPacket(ip string, now int64) (ok, block bool) {
+	if l.ipRate <= 0 {
+		return true, false
+	}
+	s, tracked := l.ips[ip]
+	if !tracked {
+		if len(l.ips) >= maxTrackedIPs {
+			if l.overflow.take(now, l.ipRate, l.ipBurst) {
+				return true, false
+			}
+			l.dropped[dropOverflow]++
+			return false, false
+		}
+		s = &ipLimit{}
+		l.ips[ip] = s
+	}
+	if s.bucket.take(now, l.ipRate, l.ipBurst) {
+		return true, false
+	}
+	l.dropped[dropIPRate]++
+	if l.blockAfter <= 0 {
+		return false, false
+	}
+	if now-s.windowStart > int64(floodWindow) {
+		s.windowStart = now
+		s.drops = 0
+	}
+	if s.drops++; s.drops >= l.blockAfter {
+		l.blocks++
+		delete(l.ips, ip)
+		log.Printf("Flood: blocking %s for %s after %d dropped packets", ip, l.blockFor, s.drops)
+		return false, true
+	}
+	return false, false
+}
+
+// AllowLookup reports whether a new Bananasplit lookup may start at
+// now.
+func (l *rateLimiter) AllowLookup(now int64) bool {
+	if l.lookupRate <= 0 {
+		return true
+	}
+	if l.lookups.take(now, l.lookupRate, l.lookupBurst) {
+		return true
+	}
+	l.dropped[dropLookup]++
+	return false
+}
+
+// Sweep forgets per-IP buckets that have been idle long enough to be
+// full again. It scans at most once per second.
+func (l *rateLimiter) Sweep(now int64) {
+	if now < l.nextSweep {
+		return
+	}
+	l.nextSweep = now + int64(time.Second)
+	if l.ipRate <= 0 {
+		return
+	}
+	refill := int64(l.ipBurst / l.ipRate * float64(time.Second))
+	for ip, s := range l.ips {
+		if now-s.bucket.last > max(refill, int64(floodWindow)) {
+			delete(l.ips, ip)
+		}
+	}
+}
+
+// SweepRateLimits runs once per step.
+func (r *Relay) SweepRateLimits(wallNanos uint64) {
+	r.limiter.Sweep(int64(wallNanos))
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/netip"
+	"sort"
 	"strings"
 	"time"
 
@@ -16,46 +19,125 @@ import (
 // traffic; the OnPacket callback on that socket forwards replies to the
 // player through the shared inbound socket.
 type PlayerSession struct {
-	PlayerAddr   string // "ip:port" — full source addr of last inbound packet
+	PlayerAddr   string // "ip:port" — full source addr; also the session key
 	Backend      string // "host:port" — backend target
 	OutboundSock *udp.Socket
+	CreatedAt    uint64 // wall-time nanoseconds
 	LastActivity uint64 // wall-time nanoseconds
+
+	// Per-session traffic: "Up" is player→backend, "Down" is
+	// backend→player.
+	PacketsUp   uint64
+	BytesUp     uint64
+	PacketsDown uint64
+	BytesDown   uint64
+
+	// replyAddr is the source address replies from Backend arrive
+	// from. The cell can't resolve hostnames, so for a hostname backend
+	// it is learned from the first reply that isn't from a retired one.
+	replyAddr string
+
+	// retired maps the reply addresses of backends this session was
+	// hot-swapped away from to the end of their grace window (wall-time
+	// nanoseconds): their replies are relayed until then, so in-flight
+	// packets survive a transfer, and dropped after.
+	retired map[string]uint64
+
+	// ProxyMode is the PROXY protocol v2 mode toward the backend (see
+	// proxyproto.go); proxyHdr is the prebuilt header for this flow.
+	ProxyMode    string
+	proxyHdr     []byte
+	preambleSent bool
 }
 
 // Relay owns the inbound UDP socket, the routing table, and the set of
 // per-player sessions. All state is plain maps — WASM is single-threaded.
 type Relay struct {
-	listenAddr     string
-	bananasplitURL string
-	bufferSize     int
-	idleTimeout    time.Duration
+	listenAddr  string
+	bananasplit *endpointSet
+	bufferSize  int
+	idleTimeout time.Duration
+	hotSwap     bool
+	swapGrace   time.Duration
+	routeTTL    time.Duration // lifetime of Bananasplit-assigned routes; 0 = forever
+	proxyMode   string        // global PROXY v2 mode; routes may override
 
 	router      *Router
 	inboundSock *udp.Socket
 
-	// playerIP -> session. Key is the host portion of PlayerAddr, to
-	// match the route map which is keyed by IP only.
+	// "ip:port" -> session. Keyed by the full source address so every
+	// player behind a shared NAT gets its own flow and outbound socket;
+	// the route map resolves a flow via its exact key first and falls
+	// back to the IP (see Router.Lookup).
 	sessions map[string]*PlayerSession
 
-	// negativeCache maps playerIP → expiry wall-time (nanoseconds).
-	// An IP present here with expiry in the future means a recent
-	// requestRoute failed; skip the HTTP call for 30s to avoid
-	// blocking the step loop on every junk packet from that IP.
-	negativeCache map[string]int64
-}
-
-// New constructs an unstarted relay. Call Start to bind the inbound
-// socket and wire the packet callback.
-func New(listenAddr, bananasplitURL string, bufferSize int, idleTimeout time.Duration) *Relay {
-	return &Relay{
-		listenAddr:     listenAddr,
-		bananasplitURL: bananasplitURL,
-		bufferSize:     bufferSize,
-		idleTimeout:    idleTimeout,
+	// fetcher defers outbound HTTP calls to the next step; pending
+	// holds the packets that arrived for each IP while its lookup is
+	// outstanding (at most one lookup per IP).
+	fetcher        *fetchQueue
+	pending        map[string]*pendingRoute
+	pendingLimit   int
+	pendingTimeout time.Duration
+	pendingDropped uint64
+
+	metrics *relayMetrics
+	events  *eventBus
+	audit   *auditLog
+	health  *healthChecker
+	breaker *circuitBreaker
+	limiter *rateLimiter
+
+	// blocklist drops every packet from the IPs and CIDRs on it; a
+	// non-empty allowlist drops every packet from those not on it.
+	blocklist *accessList
+	allowlist *accessList
+
+	// pools are the named backend pools routes may target.
+	pools map[string]*backendPool
+
+	// dest is the policy every backend a route can lead to must pass.
+	dest *destPolicy
+
+	// negativeCache holds IPs whose requestRoute recently failed; their
+	// packets are dropped without another lookup until the entry
+	// expires, so junk packets don't keep re-hitting Bananasplit.
+	negativeCache *negativeCache
+}
+
+// New constructs an unstarted relay from the parsed cell config. Call
+// Start to bind the inbound socket and wire the packet callback.
+func New(cfg appConfig) *Relay {
+	r := &Relay{
+		listenAddr:     cfg.ListenAddr,
+		bananasplit:    newEndpointSet(cfg.BananasplitURLs, cfg.BananasplitPolicy),
+		bufferSize:     cfg.BufferSize,
+		idleTimeout:    cfg.IdleTimeout,
+		hotSwap:        cfg.HotSwap,
+		swapGrace:      cfg.SwapGrace,
+		routeTTL:       cfg.RouteTTL,
+		proxyMode:      cfg.ProxyProtocol,
 		router:         NewRouter(),
 		sessions:       make(map[string]*PlayerSession),
-		negativeCache:  make(map[string]int64),
+		fetcher:        newFetchQueue(),
+		pending:        make(map[string]*pendingRoute),
+		pendingLimit:   cfg.PendingQueue,
+		pendingTimeout: cfg.PendingTimeout,
+		metrics:        newRelayMetrics(),
+		events:         newEventBus(cfg.EventBuffer),
+		audit:          newAuditLog(cfg.AuditBuffer, cfg.AuditFile),
+		negativeCache:  newNegativeCache(cfg.NegativeCacheTTL, cfg.NegativeCacheMax),
+		pools:          make(map[string]*backendPool),
+		dest:           cfg.Dest,
+		breaker:        newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		limiter:        newRateLimiter(cfg),
+		blocklist:      newAccessList(),
+		allowlist:      newAccessList(),
+	}
+	r.health = newHealthChecker(r, cfg)
+	for _, p := range cfg.Pools {
+		r.SetPool(p)
 	}
+	return r
 }
 
 // Router exposes the underlying route table so the HTTP API can manage
@@ -75,62 +157,101 @@ func (r *Relay) Start() error {
 
 	sock.OnPacket(r.onInbound)
 
+	if err := r.health.Start(); err != nil {
+		return err
+	}
+
 	log.Printf("UDP relay listening on %s", r.listenAddr)
 	return nil
 }
 
 // onInbound runs for every datagram received on the inbound socket
-// (player -> relay). Looks up the route, finds or creates a session,
-// and forwards the packet to the backend via the session's outbound
-// socket.
+// (player -> relay). Looks up the route and forwards the packet to the
+// backend via the flow's session. A packet from an IP with no route is
+// queued and the Bananasplit lookup deferred to the next step (see
+// fetchQueue); the queue is flushed once the answer arrives.
 func (r *Relay) onInbound(pkt udp.Packet) {
-	playerIP := hostOf(pkt.SrcAddr)
+	flow := pkt.SrcAddr
+	playerIP := hostOf(flow)
+	r.metrics.countPacket(dirPlayerIn, len(pkt.Payload))
+
+	// Access lists and flood protection first, before any per-packet
+	// work. A flooding IP lands on the blocklist like a manual block,
+	// so operators see it on GET /blocklist and can lift it.
+	if !r.accessAllowed(playerIP, uint64(pkt.ReceivedAt)) {
+		return
+	}
+	if ok, block := r.limiter.AllowPacket(playerIP, pkt.ReceivedAt); !ok {
+		if key, valid := canonicalAccessKey(playerIP); block && valid {
+			r.Block(key, uint64(pkt.ReceivedAt)+uint64(r.limiter.blockFor), "flood")
+		}
+		return
+	}
 
-	backend, hasRoute := r.router.Get(playerIP)
+	// Exact, prefix and default routes are all consulted here, so only
+	// players no route covers go to Bananasplit.
+	backend, hasRoute := r.router.Lookup(flow)
 	if !hasRoute {
 		// Check negative cache: if a recent requestRoute failed for this
-		// IP, skip the blocking HTTP call for 30s so junk packets from the
-		// same IP don't stall the step loop repeatedly.
-		nowNs := time.Now().UnixNano()
-		if exp, cached := r.negativeCache[playerIP]; cached && nowNs < exp {
+		// IP, drop until the entry expires so junk packets from the same
+		// IP don't keep re-hitting Bananasplit.
+		if r.negativeCache.Blocked(playerIP, time.Now().UnixNano()) {
+			r.metrics.negativeCacheHits++
 			return
 		}
-
-		// No route cached. Ask Bananasplit synchronously. This blocks
-		// the step loop for the duration of the HTTP call — acceptable
-		// since this only happens for the first packet of a session.
-		resolved, err := r.requestRoute(playerIP)
-		if err != nil {
-			log.Printf("Failed to get route for %s: %v", playerIP, err)
-			// Cache the failure for 30s to avoid repeated blocking calls.
-			r.negativeCache[playerIP] = time.Now().UnixNano() + int64(30*time.Second)
+		// Only a packet that would start a new lookup spends from the
+		// global lookup budget; others queue behind the one in flight.
+		if _, inFlight := r.pending[playerIP]; !inFlight && !r.limiter.AllowLookup(pkt.ReceivedAt) {
 			return
 		}
-		// Clear any stale negative cache entry on success.
-		delete(r.negativeCache, playerIP)
-		r.router.Set(playerIP, resolved)
-		backend = resolved
+		r.enqueuePending(playerIP, pkt)
+		return
 	}
 
-	sess, err := r.getOrCreateSession(playerIP, pkt.SrcAddr, backend, pkt.ReceivedAt)
+	r.forward(flow, backend, pkt.Payload, pkt.ReceivedAt)
+}
+
+// forward sends one player packet to backend through the flow's
+// session, creating the session on first use.
+func (r *Relay) forward(flow, backend string, payload []byte, receivedAt int64) {
+	sess, err := r.getOrCreateSession(flow, backend, receivedAt)
 	if err != nil {
-		log.Printf("Session error for %s: %v", playerIP, err)
+		log.Printf("Session error for %s: %v", flow, err)
 		return
 	}
 
-	// Remember the most-recent source addr so replies land on the right
-	// ephemeral port.
-	sess.PlayerAddr = pkt.SrcAddr
-	sess.LastActivity = uint64(pkt.ReceivedAt)
+	sess.LastActivity = uint64(receivedAt)
+
+	// PROXY v2: either prefix every datagram with the header or send it
+	// once ahead of the session's first payload. Metrics and session
+	// counters keep measuring the player's payload only.
+	wire := payload
+	switch sess.ProxyMode {
+	case proxyHeader:
+		if sess.proxyHdr != nil {
+			wire = make([]byte, 0, len(sess.proxyHdr)+len(payload))
+			wire = append(append(wire, sess.proxyHdr...), payload...)
+		}
+	case proxyPreamble:
+		if !sess.preambleSent && sess.proxyHdr != nil {
+			_, _ = sess.OutboundSock.Send(sess.Backend, sess.proxyHdr)
+			sess.preambleSent = true
+		}
+	}
 
 	// Native calls WriteToUDP without checking its error — packet drops
 	// are silent. Cell matches that: host-side send failures are
 	// already logged by Pulp-ext-udp at source, so double-logging here
-	// would be noise parity tests could trip on.
-	_, _ = sess.OutboundSock.Send(sess.Backend, pkt.Payload)
+	// would be noise parity tests could trip on. Only the metric sees
+	// the outcome.
+	if _, err := sess.OutboundSock.Send(sess.Backend, wire); err == nil {
+		r.metrics.countPacket(dirBackendOut, len(payload))
+		sess.PacketsUp++
+		sess.BytesUp += uint64(len(payload))
+	}
 }
 
-// getOrCreateSession returns the existing session for playerIP or
+// getOrCreateSession returns the existing session for flow or
 // synchronously opens a new outbound socket and wires its callback.
 // Creating a socket in the step loop is acceptable — it's a single
 // host call, no goroutines, no sleeps.
@@ -139,50 +260,112 @@ func (r *Relay) onInbound(pkt udp.Packet) {
 // "Don't check backend mismatch" comment. The only way to change a
 // session's backend is via UpdateSessionBackend, which callers invoke
 // from the HTTP setRoute handler before the next packet arrives.
-func (r *Relay) getOrCreateSession(playerIP, playerAddr, backend string, now int64) (*PlayerSession, error) {
-	if sess, ok := r.sessions[playerIP]; ok {
+func (r *Relay) getOrCreateSession(flow, backend string, now int64) (*PlayerSession, error) {
+	if sess, ok := r.sessions[flow]; ok {
 		return sess, nil
 	}
 
+	// A pool route picks its member here, once per session, and a new
+	// session never starts on a backend known to be down when a fallback
+	// is configured.
+	backend = r.resolveBackend(backend, flow)
+
 	outbound, err := udp.Listen("", r.bufferSize) // ephemeral local port
 	if err != nil {
 		return nil, fmt.Errorf("outbound udp listen: %w", err)
 	}
 
 	sess := &PlayerSession{
-		PlayerAddr:   playerAddr,
+		PlayerAddr:   flow,
 		Backend:      backend,
 		OutboundSock: outbound,
+		CreatedAt:    uint64(now),
 		LastActivity: uint64(now),
+		ProxyMode:    r.proxyModeFor(flow),
+		replyAddr:    literalReplyAddr(backend),
+	}
+	if sess.ProxyMode != proxyOff {
+		sess.proxyHdr = buildProxyV2Header(flow, listenPort(r.listenAddr))
 	}
 
 	// The outbound socket's packet callback carries backend responses
-	// back to the player. We bind playerIP (not the captured PlayerAddr)
-	// because the player's ephemeral port may change — always read the
-	// current PlayerAddr from the session map at reply time.
-	ip := playerIP
+	// back to the player. Each flow owns its socket, so replies always
+	// go to the flow's own source address; the session is re-read from
+	// the map so a reply racing a close is dropped. After a hot-swap,
+	// late replies from a previous backend are relayed only until its
+	// grace window ends (see acceptReply).
+	key := flow
 	outbound.OnPacket(func(pkt udp.Packet) {
-		cur, ok := r.sessions[ip]
+		cur, ok := r.sessions[key]
 		if !ok {
 			return
 		}
+		if !cur.acceptReply(pkt.SrcAddr, uint64(pkt.ReceivedAt)) {
+			return
+		}
 		cur.LastActivity = uint64(pkt.ReceivedAt)
+		r.metrics.countPacket(dirBackendIn, len(pkt.Payload))
 		// Match native: no error logging on reply write — native's
 		// readBackendResponses does not check WriteToUDP's return.
-		_, _ = r.inboundSock.Send(cur.PlayerAddr, pkt.Payload)
+		if _, err := r.inboundSock.Send(cur.PlayerAddr, pkt.Payload); err == nil {
+			r.metrics.countPacket(dirPlayerOut, len(pkt.Payload))
+			cur.PacketsDown++
+			cur.BytesDown += uint64(len(pkt.Payload))
+		}
 	})
 
-	r.sessions[playerIP] = sess
-	log.Printf("Session created: %s → %s", playerIP, backend)
+	r.sessions[flow] = sess
+	r.metrics.sessionsCreated++
+	log.Printf("Session created: %s → %s", flow, backend)
+	r.emit(relayEvent{Type: evSessionCreated, PlayerIP: flow, Backend: backend})
 	return sess, nil
 }
 
-// UpdateSessionBackend closes the current session for playerIP and
-// updates the route. The next packet from that player will create a
-// new session bound to newBackend.
+// sessionKeys returns the session keys selected by key: the single
+// flow when key is "ip:port", every flow from that IP when key is a
+// bare IP, or every flow currently resolved through a prefix or the
+// default route when key names one.
+func (r *Relay) sessionKeys(key string) []string {
+	if kind := routeKind(key); kind != routeExact {
+		var keys []string
+		for flow := range r.sessions {
+			if m, _ := r.router.Match(flow); m == key {
+				keys = append(keys, flow)
+			}
+		}
+		return keys
+	}
+	if isFlowKey(key) {
+		if _, ok := r.sessions[key]; ok {
+			return []string{key}
+		}
+		return nil
+	}
+	var keys []string
+	for flow := range r.sessions {
+		if hostOf(flow) == key {
+			keys = append(keys, flow)
+		}
+	}
+	return keys
+}
+
+// UpdateSessionBackend rebinds the sessions selected by key to
+// newBackend and updates the route. Returns the flows it rebound.
+//
+// By default each affected session is closed and the next packet from
+// the flow creates a new session bound to newBackend. With hot_swap
+// enabled the session keeps its outbound socket instead: Backend flips
+// in place, so the next packet goes to newBackend on the same local
+// port, and replies from the old backend keep flowing to the player for
+// swap_grace.
+//
+// key is either a flow ("ip:port") or a bare IP. An IP-level update
+// skips flows that carry their own exact route — those follow their
+// flow route, not the IP fallback.
 //
 // Mirrors native Peel's UpdateSessionBackend: only acts when a session
-// exists for playerIP, and only after the new backend passes a basic
+// exists for key, and only after the new backend passes a basic
 // address validation (native uses net.ResolveUDPAddr; the cell has no
 // resolver in-WASM so it does a syntactic host:port check instead).
 // Rejecting malformed input here matches the native short-circuit and
@@ -191,18 +374,106 @@ func (r *Relay) getOrCreateSession(playerIP, playerAddr, backend string, now int
 //
 // Side-effect order matches native: close session (which logs "session
 // closed") → log "session backend updated" → Router.Set. The final
-// Router.Set is redundant with the caller's earlier Router.Set but is
-// preserved for byte-parity of side-effect ordering.
-func (r *Relay) UpdateSessionBackend(playerIP, newBackend string) {
-	if _, ok := r.sessions[playerIP]; !ok {
-		return
+// Router.Set is redundant with the caller's earlier Router.Set, so it
+// only writes when the route doesn't already point at newBackend —
+// that keeps the caller's TTL intact.
+func (r *Relay) UpdateSessionBackend(key, newBackend string) []string {
+	flows := r.sessionKeys(key)
+	if len(flows) == 0 {
+		return nil
+	}
+	if r.checkTarget(newBackend) != nil {
+		return nil
+	}
+	var rebound []string
+	for _, flow := range flows {
+		if flow != key {
+			if _, own := r.router.Get(flow); own {
+				continue
+			}
+		}
+		oldBackend := r.sessions[flow].Backend
+		target := newBackend
+		if r.hotSwap {
+			target = r.resolveBackend(newBackend, flow)
+			r.swapSessionBackend(flow, target)
+		} else {
+			r.closeSessionLocked(flow, closeBackendChange)
+		}
+		log.Printf("Session backend updated: %s → %s", flow, target)
+		r.emit(relayEvent{Type: evSessionBackend, PlayerIP: flow, Backend: target, OldBackend: oldBackend})
+		rebound = append(rebound, flow)
+	}
+	if cur, ok := r.router.Get(key); !ok || cur != newBackend {
+		r.router.Set(key, newBackend)
 	}
-	if !validBackendAddr(newBackend) {
+	return rebound
+}
+
+// swapSessionBackend flips a live session to newBackend without
+// touching its outbound socket, opening the grace window for replies
+// from the backend it was bound to.
+func (r *Relay) swapSessionBackend(flow, newBackend string) {
+	sess, ok := r.sessions[flow]
+	if !ok || sess.Backend == newBackend {
 		return
 	}
-	r.closeSessionLocked(playerIP)
-	log.Printf("Session backend updated: %s → %s", playerIP, newBackend)
-	r.router.Set(playerIP, newBackend)
+	if sess.replyAddr != "" {
+		if sess.retired == nil {
+			sess.retired = make(map[string]uint64)
+		}
+		sess.retired[sess.replyAddr] = uint64(time.Now().UnixNano()) + uint64(r.swapGrace)
+	}
+	sess.Backend = newBackend
+	sess.replyAddr = literalReplyAddr(newBackend)
+	// Swapping back to a retired backend makes it current again.
+	delete(sess.retired, sess.replyAddr)
+
+	// The new backend has never seen this flow: pick up the new route's
+	// PROXY mode and re-send the preamble if one is used.
+	sess.ProxyMode = r.proxyModeFor(flow)
+	if sess.ProxyMode != proxyOff && sess.proxyHdr == nil {
+		sess.proxyHdr = buildProxyV2Header(flow, listenPort(r.listenAddr))
+	}
+	sess.preambleSent = false
+}
+
+// acceptReply reports whether a backend reply from src, received at
+// wallNanos, is relayed to the player. Replies from a retired backend
+// are relayed only within its grace window. The first reply from any
+// other address teaches a hostname backend its reply address.
+//
+// A hostname backend swapped away from before it ever replied has no
+// known address, so its late replies can't be told from the new
+// backend's and are relayed without a time limit.
+func (s *PlayerSession) acceptReply(src string, wallNanos uint64) bool {
+	if until, ok := s.retired[src]; ok {
+		return wallNanos <= until
+	}
+	if s.replyAddr == "" {
+		s.replyAddr = src
+	}
+	return true
+}
+
+// literalReplyAddr returns the address replies from backend arrive
+// from when backend is a literal ip:port, written the way packet
+// sources are ("ip:port" or "[ipv6]:port"), or "" for a hostname.
+func literalReplyAddr(backend string) string {
+	ap, err := netip.ParseAddrPort(backend)
+	if err != nil {
+		return ""
+	}
+	return ap.String()
+}
+
+// proxyModeFor returns the PROXY protocol mode for flow: its route's
+// override when set, otherwise the global mode.
+func (r *Relay) proxyModeFor(flow string) string {
+	if e, ok := r.router.LookupEntry(flow); ok && e.Proxy != "" {
+		return e.Proxy
+	}
+	return r.proxyMode
 }
 
 // validBackendAddr returns true when addr parses as host:port — the
@@ -229,6 +500,12 @@ func validBackendAddr(addr string) bool {
 	if i < 0 || i == len(addr)-1 {
 		return false
 	}
+	// An unbracketed IPv6 host ("2001:db8::5") would otherwise parse as
+	// host "2001:db8:" and port "5"; native rejects it as "too many
+	// colons in address".
+	if strings.Contains(addr[:i], ":") {
+		return false
+	}
 	return isPort(addr[i+1:])
 }
 
@@ -250,26 +527,46 @@ func isPort(s string) bool {
 	return n > 0
 }
 
-// CloseSession drops the session for playerIP and tears down its
-// outbound socket. Safe to call for an unknown playerIP.
-func (r *Relay) CloseSession(playerIP string) {
-	r.closeSessionLocked(playerIP)
+// DeleteRoute removes the route stored under key and closes the
+// sessions key selects. source ("api", "sync", "watch") is recorded on
+// the route.deleted event.
+func (r *Relay) DeleteRoute(key, source string) {
+	backend, _ := r.router.Get(key)
+	flows := r.sessionKeys(key) // before Delete: prefix matches depend on it
+	r.router.Delete(key)
+	for _, flow := range flows {
+		r.closeSessionLocked(flow, closeRouteDeleted)
+	}
+	log.Printf("Route deleted: %s", key)
+	r.emit(relayEvent{Type: evRouteDeleted, PlayerIP: key, OldBackend: backend, Reason: source})
+}
+
+// CloseSession drops the sessions selected by key (one flow for
+// "ip:port", every flow of the IP for a bare IP) and tears down their
+// outbound sockets. reason labels the close in metrics. Safe to call
+// for an unknown key.
+func (r *Relay) CloseSession(key, reason string) {
+	for _, flow := range r.sessionKeys(key) {
+		r.closeSessionLocked(flow, reason)
+	}
 }
 
-// closeSessionLocked is the inner close — identical to CloseSession
-// but named to match the Go-stdlib convention for the no-lock variant.
-// In WASM there is no lock, but the naming signals intent.
+// closeSessionLocked is the inner close for a single flow — named to
+// match the Go-stdlib convention for the no-lock variant. In WASM there
+// is no lock, but the naming signals intent.
 //
 // Native CloseSession ignores OutboundConn.Close's return; we do the
 // same so no cell-only log line can diverge from native output.
-func (r *Relay) closeSessionLocked(playerIP string) {
-	sess, ok := r.sessions[playerIP]
+func (r *Relay) closeSessionLocked(flow, reason string) {
+	sess, ok := r.sessions[flow]
 	if !ok {
 		return
 	}
 	_ = sess.OutboundSock.Close()
-	delete(r.sessions, playerIP)
-	log.Printf("Session closed: %s", playerIP)
+	delete(r.sessions, flow)
+	r.metrics.sessionsClosed[reason]++
+	log.Printf("Session closed: %s", flow)
+	r.emit(relayEvent{Type: evSessionClosed, PlayerIP: flow, Backend: sess.Backend, Reason: reason})
 }
 
 // SweepIdle runs once per step. Closes sessions that have been silent
@@ -278,55 +575,156 @@ func (r *Relay) SweepIdle(wallNanos uint64) {
 	if r.idleTimeout <= 0 {
 		return
 	}
+	r.metrics.idleSweeps++
 	cutoff := uint64(r.idleTimeout)
-	for ip, sess := range r.sessions {
+	for flow, sess := range r.sessions {
 		if wallNanos > sess.LastActivity && wallNanos-sess.LastActivity > cutoff {
-			r.closeSessionLocked(ip)
+			r.closeSessionLocked(flow, closeIdle)
 		}
 	}
 }
 
+// SweepRoutes runs once per step. Evicts routes whose TTL has run
+// out; sessions still bound to them keep forwarding and the flow's
+// next new-session lookup re-asks Bananasplit.
+func (r *Relay) SweepRoutes(wallNanos uint64) {
+	for _, key := range r.router.Expire(wallNanos) {
+		log.Printf("Route expired: %s", key)
+		r.emit(relayEvent{Type: evRouteDeleted, PlayerIP: key, Reason: "expired"})
+	}
+}
+
+// expiryAfter converts a TTL into a Router deadline; a non-positive
+// TTL means the route never expires.
+func expiryAfter(ttl time.Duration) uint64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return uint64(time.Now().UnixNano()) + uint64(ttl)
+}
+
+// routeRequestTimeout bounds each /route-request call. The call blocks
+// the step loop, so a slow or dead Bananasplit must not stall packet
+// forwarding for longer.
+const routeRequestTimeout = 5 * time.Second
+
 // requestRoute asks Bananasplit for the backend that should serve
-// playerIP. Synchronous outbound HTTP via the pulp transport.
-func (r *Relay) requestRoute(playerIP string) (string, error) {
-	if r.bananasplitURL == "" {
-		return "", fmt.Errorf("bananasplit_url not configured")
+// playerIP. The HTTP call is made on the next step (see fetchQueue) and
+// done is invoked once it finishes. With several Bananasplit URLs a
+// transport error, timeout or 5xx is retried on the next endpoint
+// until each has been tried once.
+func (r *Relay) requestRoute(playerIP string, done func(string, error)) {
+	if len(r.bananasplit.endpoints) == 0 {
+		err := &routeError{class: "unconfigured", err: fmt.Errorf("bananasplit_url not configured")}
+		r.metrics.routeErrors[err.class]++
+		done("", err)
+		return
+	}
+
+	// While Bananasplit is failing the breaker fails lookups at once
+	// rather than spending a Fetch (and its timeout) on each new IP.
+	ok, probe := r.breaker.Allow(time.Now().UnixNano())
+	if !ok {
+		err := &routeError{class: "circuit_open", err: fmt.Errorf("bananasplit circuit open")}
+		r.metrics.routeErrors[err.class]++
+		done("", err)
+		return
 	}
 
 	body, _ := json.Marshal(map[string]string{"player_ip": playerIP})
 
-	// 5s budget: this call blocks the step loop, so a slow or dead
-	// Bananasplit must not stall packet forwarding. Fail fast, log, and
-	// drop the packet — the player's client will resend and a later
-	// fetch attempt will re-hit Bananasplit.
-	resp, err := pulp.HTTP.Fetch(pulp.HTTPFetchRequest{
-		Method:  "POST",
-		URL:     r.bananasplitURL + "/route-request",
-		Headers: map[string]string{"Content-Type": "application/json"},
-		Body:    body,
-		Timeout: 5 * time.Second,
-	})
-	if err != nil {
-		return "", fmt.Errorf("route request: %w", err)
+	// routeRequestTimeout per endpoint. Fail fast, log, and drop the queue — the player's client will
+	// resend and a later fetch attempt will re-hit Bananasplit.
+	start := time.Now()
+	var tried []*bananasplitEndpoint
+	var attempt func()
+	attempt = func() {
+		ep := r.bananasplit.Pick(time.Now().UnixNano(), tried...)
+		tried = append(tried, ep)
+		sent := time.Now()
+		r.fetcher.Submit(pulp.HTTPFetchRequest{
+			Method:  "POST",
+			URL:     ep.URL + "/route-request",
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body:    body,
+			Timeout: routeRequestTimeout,
+		}, func(res fetchResult) {
+			now := time.Now()
+			epErr := endpointFailed(res)
+			ep.latency.Observe(now.Sub(sent).Seconds())
+			r.bananasplit.Record(ep, epErr, now.UnixNano())
+			if epErr != nil && len(tried) < len(r.bananasplit.endpoints) {
+				log.Printf("Route request to %s failed, trying next endpoint: %v", ep.URL, epErr)
+				attempt()
+				return
+			}
+			backend, err := parseRouteResponse(playerIP, res)
+			if err == nil {
+				// Bananasplit answers are held to the same destination
+				// policy as control-API writes.
+				if perr := r.checkTarget(backend); perr != nil {
+					err = &routeError{class: "policy", err: fmt.Errorf("route response %s for %s rejected: %w", backend, playerIP, perr)}
+				}
+			}
+			r.metrics.observeRouteRequest(now.Sub(start), err)
+			r.breaker.Record(probe, epErr != nil, now.UnixNano())
+			done(backend, err)
+		})
+	}
+	attempt()
+}
+
+// parseRouteResponse turns a /route-request result into a backend.
+// Failures are *routeError so metrics can group them by class.
+func parseRouteResponse(playerIP string, res fetchResult) (string, error) {
+	if res.Err != nil {
+		return "", &routeError{class: "transport", err: fmt.Errorf("route request: %w", res.Err)}
 	}
-	if resp.Status != 200 {
-		return "", fmt.Errorf("route request failed: %d %s", resp.Status, resp.Body)
+	if res.Status != 200 {
+		return "", &routeError{class: "status", err: fmt.Errorf("route request failed: %d %s", res.Status, res.Body)}
 	}
 
 	var parsed struct {
 		Backend string `json:"backend"`
 	}
-	if err := json.Unmarshal(resp.Body, &parsed); err != nil {
-		return "", fmt.Errorf("decode route response: %w", err)
+	if err := json.Unmarshal(res.Body, &parsed); err != nil {
+		return "", &routeError{class: "decode", err: fmt.Errorf("decode route response: %w", err)}
 	}
 	if parsed.Backend == "" {
-		return "", fmt.Errorf("empty backend in route response")
+		return "", &routeError{class: "empty", err: fmt.Errorf("empty backend in route response")}
 	}
 
 	log.Printf("Route assigned: %s -> %s", playerIP, parsed.Backend)
 	return parsed.Backend, nil
 }
 
+// routeError is a failed route lookup tagged with a coarse class
+// ("unconfigured", "circuit_open", "transport", "status", "decode",
+// "empty", "policy").
+type routeError struct {
+	class string
+	err   error
+}
+
+func (e *routeError) Error() string { return e.err.Error() }
+func (e *routeError) Unwrap() error { return e.err }
+
+// routeErrorClass returns the class of a route lookup error, or
+// "other" for errors that didn't come from parseRouteResponse.
+func routeErrorClass(err error) string {
+	var re *routeError
+	if errors.As(err, &re) {
+		return re.class
+	}
+	return "other"
+}
+
+// PollFetches makes the HTTP calls queued since the last step and runs
+// their callbacks. Call once per step, before the sweeps.
+func (r *Relay) PollFetches() {
+	r.fetcher.Poll()
+}
+
 // Stop tears down every session and closes the inbound socket. Intended
 // for OnShutdown — idempotent.
 //
@@ -341,6 +739,8 @@ func (r *Relay) Stop() {
 		_ = sess.OutboundSock.Close()
 	}
 	r.sessions = make(map[string]*PlayerSession)
+	r.pending = make(map[string]*pendingRoute)
+	r.health.Stop()
 	if r.inboundSock != nil {
 		_ = r.inboundSock.Close()
 		r.inboundSock = nil
@@ -362,3 +762,58 @@ func hostOf(addr string) string {
 	return addr
 }
 
+// isFlowKey reports whether key names a single flow ("ip:port" or
+// "[ipv6]:port") rather than a bare IP. A bare IPv6 address has several
+// colons and no brackets, so only one colon (IPv4) or a bracketed host
+// counts as carrying a port.
+func isFlowKey(key string) bool {
+	if strings.HasPrefix(key, "[") {
+		return strings.Contains(key, "]:")
+	}
+	return strings.Count(key, ":") == 1
+}
+
+// sessionInfo is the control-API view of a PlayerSession.
+type sessionInfo struct {
+	PlayerAddr   string    `json:"player_addr"`
+	Backend      string    `json:"backend"`
+	LocalAddr    string    `json:"local_addr"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActivity time.Time `json:"last_activity"`
+	PacketsUp    uint64    `json:"packets_up"`
+	BytesUp      uint64    `json:"bytes_up"`
+	PacketsDown  uint64    `json:"packets_down"`
+	BytesDown    uint64    `json:"bytes_down"`
+}
+
+// Sessions returns a snapshot of the sessions selected by key (see
+// sessionKeys), or of every session when key is empty, sorted by
+// player address.
+func (r *Relay) Sessions(key string) []sessionInfo {
+	var flows []string
+	if key == "" {
+		for flow := range r.sessions {
+			flows = append(flows, flow)
+		}
+	} else {
+		flows = r.sessionKeys(key)
+	}
+	sort.Strings(flows)
+
+	out := make([]sessionInfo, 0, len(flows))
+	for _, flow := range flows {
+		sess := r.sessions[flow]
+		out = append(out, sessionInfo{
+			PlayerAddr:   sess.PlayerAddr,
+			Backend:      sess.Backend,
+			LocalAddr:    sess.OutboundSock.LocalAddr(),
+			CreatedAt:    time.Unix(0, int64(sess.CreatedAt)).UTC(),
+			LastActivity: time.Unix(0, int64(sess.LastActivity)).UTC(),
+			PacketsUp:    sess.PacketsUp,
+			BytesUp:      sess.BytesUp,
+			PacketsDown:  sess.PacketsDown,
+			BytesDown:    sess.BytesDown,
+		})
+	}
+	return out
+}
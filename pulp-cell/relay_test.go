@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHotSwapGrace(t *testing.T) {
+	const grace = 5 * time.Second
+	r := newTestRelay(t)
+	r.swapGrace = grace
+
+	swap := func(s *PlayerSession, backend string) uint64 {
+		r.sessions["203.0.113.50:40000"] = s
+		r.swapSessionBackend("203.0.113.50:40000", backend)
+		return uint64(time.Now().UnixNano())
+	}
+	const in, past = uint64(grace / 2), uint64(2 * grace)
+
+	t.Run("ip backend", func(t *testing.T) {
+		s := &PlayerSession{Backend: "10.0.0.1:5520", replyAddr: literalReplyAddr("10.0.0.1:5520")}
+		at := swap(s, "10.0.0.2:5520")
+		if !s.acceptReply("10.0.0.1:5520", at+in) {
+			t.Error("old backend refused within grace")
+		}
+		if s.acceptReply("10.0.0.1:5520", at+past) {
+			t.Error("old backend accepted after grace")
+		}
+		if !s.acceptReply("10.0.0.2:5520", at+past) {
+			t.Error("new backend refused")
+		}
+	})
+
+	t.Run("hostname backend", func(t *testing.T) {
+		s := &PlayerSession{Backend: "game-1:5520", replyAddr: literalReplyAddr("game-1:5520")}
+		// Learn game-1's address from its first reply.
+		if !s.acceptReply("10.0.0.1:5520", 0) {
+			t.Fatal("first reply refused")
+		}
+		at := swap(s, "game-2:5520")
+		if !s.acceptReply("10.0.0.1:5520", at+in) {
+			t.Error("old backend refused within grace")
+		}
+		if !s.acceptReply("10.0.0.2:5520", at+in) {
+			t.Error("new backend refused")
+		}
+		if s.acceptReply("10.0.0.1:5520", at+past) {
+			t.Error("old backend accepted after grace")
+		}
+	})
+
+	t.Run("second swap within grace", func(t *testing.T) {
+		s := &PlayerSession{Backend: "10.0.0.1:5520", replyAddr: literalReplyAddr("10.0.0.1:5520")}
+		at := swap(s, "10.0.0.2:5520")
+		swap(s, "10.0.0.3:5520")
+		if s.acceptReply("10.0.0.1:5520", at+past) {
+			t.Error("first backend accepted after its grace")
+		}
+		if !s.acceptReply("10.0.0.2:5520", at+in) {
+			t.Error("second backend refused within its grace")
+		}
+		if !s.acceptReply("10.0.0.3:5520", at+past) {
+			t.Error("current backend refused")
+		}
+	})
+
+	t.Run("swap back", func(t *testing.T) {
+		s := &PlayerSession{Backend: "10.0.0.1:5520", replyAddr: literalReplyAddr("10.0.0.1:5520")}
+		at := swap(s, "10.0.0.2:5520")
+		swap(s, "10.0.0.1:5520")
+		if !s.acceptReply("10.0.0.1:5520", at+past) {
+			t.Error("current backend refused after swapping back")
+		}
+		if s.acceptReply("10.0.0.2:5520", at+past) {
+			t.Error("swapped-away backend accepted after grace")
+		}
+	})
+}
@@ -1,42 +1,213 @@
 package main
 
-// Router maps player IPs to backend addresses.
+import (
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Route kinds, told apart by the shape of the key:
+//
+//   - exact: a bare player IP (every flow from that address) or a full
+//     "ip:port" flow (one player behind a shared NAT).
+//   - prefix: a CIDR such as "10.0.0.0/8", matching every player inside
+//     it; the longest matching prefix wins.
+//   - default: the key "default", matching any player no other route
+//     does, so unknown players skip the Bananasplit lookup.
+const (
+	routeExact   = "exact"
+	routePrefix  = "prefix"
+	routeDefault = "default"
+)
+
+// defaultRouteKey is the Router key of the catch-all route.
+const defaultRouteKey = "default"
+
+// routeKind classifies a route key.
+func routeKind(key string) string {
+	switch {
+	case key == defaultRouteKey:
+		return routeDefault
+	case strings.Contains(key, "/"):
+		return routePrefix
+	default:
+		return routeExact
+	}
+}
+
+// canonicalRouteKey returns key in the form Router stores it: prefix
+// keys are masked to their network address ("10.1.2.3/8" becomes
+// "10.0.0.0/8"). It reports false for a malformed CIDR.
+func canonicalRouteKey(key string) (string, bool) {
+	if routeKind(key) != routePrefix {
+		return key, true
+	}
+	p, err := netip.ParsePrefix(key)
+	if err != nil {
+		return "", false
+	}
+	return p.Masked().String(), true
+}
+
+// Router maps players to backend addresses. Exact routes are tried
+// first (flow, then IP), then prefix routes longest-first, then the
+// default route.
 //
 // Single-threaded in WASM — no sync.RWMutex needed. The step loop is
 // serial, so every Get/Set/Delete/List call happens from the same
 // goroutine that owns the map.
 type Router struct {
-	routes map[string]string
+	routes map[string]routeEntry
+
+	// prefixes indexes the prefix keys of routes, longest first.
+	prefixes []netip.Prefix
+}
+
+// routeEntry is one route. Expires is a wall-time deadline in
+// nanoseconds; zero means the route never expires. Proxy overrides the
+// global PROXY protocol mode for sessions on this route ("" inherits).
+type routeEntry struct {
+	Backend string
+	Expires uint64
+	Proxy   string
 }
 
 // NewRouter creates an empty router.
 func NewRouter() *Router {
 	return &Router{
-		routes: make(map[string]string),
+		routes: make(map[string]routeEntry),
 	}
 }
 
-// Set maps a player IP to a backend.
-func (r *Router) Set(playerIP, backend string) {
-	r.routes[playerIP] = backend
+// Set maps a route key to a backend with no expiry.
+func (r *Router) Set(key, backend string) {
+	r.SetEntry(key, routeEntry{Backend: backend})
+}
+
+// SetExpiring maps a route key to a backend until the wall-time
+// deadline expires (nanoseconds; zero means never).
+func (r *Router) SetExpiring(key, backend string, expires uint64) {
+	r.SetEntry(key, routeEntry{Backend: backend, Expires: expires})
 }
 
-// Get returns the backend for a player IP.
-func (r *Router) Get(playerIP string) (string, bool) {
-	backend, ok := r.routes[playerIP]
-	return backend, ok
+// SetEntry stores a fully specified route under key.
+func (r *Router) SetEntry(key string, e routeEntry) {
+	_, existed := r.routes[key]
+	r.routes[key] = e
+	if !existed && routeKind(key) == routePrefix {
+		r.reindex()
+	}
 }
 
-// Delete removes a player's route.
-func (r *Router) Delete(playerIP string) {
-	delete(r.routes, playerIP)
+// Get returns the backend stored under exactly key (no fallback).
+func (r *Router) Get(key string) (string, bool) {
+	e, ok := r.routes[key]
+	return e.Backend, ok
+}
+
+// Lookup resolves the backend for a flow ("ip:port"): an exact flow
+// route wins, then the route for the flow's IP, then the longest
+// matching prefix route, then the default route.
+func (r *Router) Lookup(flow string) (string, bool) {
+	e, ok := r.LookupEntry(flow)
+	return e.Backend, ok
+}
+
+// LookupEntry is Lookup returning the whole route entry.
+func (r *Router) LookupEntry(flow string) (routeEntry, bool) {
+	key, ok := r.Match(flow)
+	return r.routes[key], ok
+}
+
+// Match returns the key of the route Lookup would use for flow.
+func (r *Router) Match(flow string) (string, bool) {
+	if _, ok := r.routes[flow]; ok {
+		return flow, true
+	}
+	if _, ok := r.routes[hostOf(flow)]; ok {
+		return hostOf(flow), true
+	}
+	if len(r.prefixes) > 0 {
+		if addr, ok := parsePlayerAddr(flow); ok {
+			for _, p := range r.prefixes {
+				if p.Contains(addr) {
+					return p.String(), true
+				}
+			}
+		}
+	}
+	if _, ok := r.routes[defaultRouteKey]; ok {
+		return defaultRouteKey, true
+	}
+	return "", false
+}
+
+// Delete removes a route.
+func (r *Router) Delete(key string) {
+	if _, ok := r.routes[key]; !ok {
+		return
+	}
+	delete(r.routes, key)
+	if routeKind(key) == routePrefix {
+		r.reindex()
+	}
+}
+
+// reindex rebuilds the prefix index from routes.
+func (r *Router) reindex() {
+	r.prefixes = r.prefixes[:0]
+	for key := range r.routes {
+		if routeKind(key) != routePrefix {
+			continue
+		}
+		if p, err := netip.ParsePrefix(key); err == nil {
+			r.prefixes = append(r.prefixes, p)
+		}
+	}
+	sort.Slice(r.prefixes, func(i, j int) bool {
+		return r.prefixes[i].Bits() > r.prefixes[j].Bits()
+	})
+}
+
+// parsePlayerAddr extracts the IP from a flow or bare IP key, with
+// IPv4-mapped IPv6 addresses unmapped so they match IPv4 prefixes.
+func parsePlayerAddr(key string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(key); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	if a, err := netip.ParseAddr(key); err == nil {
+		return a.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// Expire removes every route whose deadline is at or before now
+// (wall-time nanoseconds) and returns the evicted keys.
+func (r *Router) Expire(now uint64) []string {
+	var evicted []string
+	for k, e := range r.routes {
+		if e.Expires != 0 && e.Expires <= now {
+			r.Delete(k)
+			evicted = append(evicted, k)
+		}
+	}
+	return evicted
 }
 
 // List returns a copy of all current routes (for debugging).
 func (r *Router) List() map[string]string {
 	out := make(map[string]string, len(r.routes))
-	for k, v := range r.routes {
-		out[k] = v
+	for k, e := range r.routes {
+		out[k] = e.Backend
+	}
+	return out
+}
+
+// Entries returns a copy of all current routes with their expiry.
+func (r *Router) Entries() map[string]routeEntry {
+	out := make(map[string]routeEntry, len(r.routes))
+	for k, e := range r.routes {
+		out[k] = e
 	}
 	return out
 }
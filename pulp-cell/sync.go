@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/BananaLabs-OSS/Fiber/pulp"
+)
+
+// routeSyncer reconciles Router against Bananasplit's authoritative
+// route set: once on the first step after boot and then every
+// interval. Routes missing locally are added, differing ones changed
+// (rebinding live sessions) and local routes Bananasplit no longer has
+// are removed. Every difference is logged as drift — outside of the
+// startup sync there should be none if every push reached Peel.
+type routeSyncer struct {
+	relay    *Relay
+	interval time.Duration
+	next     uint64 // wall-time nanoseconds of the next sync
+	inFlight bool
+}
+
+func newRouteSyncer(relay *Relay, interval time.Duration) *routeSyncer {
+	return &routeSyncer{relay: relay, interval: interval}
+}
+
+// Step runs once per step and starts a sync when one is due.
+func (s *routeSyncer) Step(wallNanos uint64) {
+	if s.interval <= 0 || s.inFlight || wallNanos < s.next {
+		return
+	}
+	s.next = wallNanos + uint64(s.interval)
+	s.run()
+}
+
+// run fetches GET {bananasplit}/route-sync, which answers
+// {"routes": {"<player_ip>": "<backend>", ...}}, and applies the diff
+// once the response arrives.
+func (s *routeSyncer) run() {
+	r := s.relay
+	ep := r.bananasplit.Pick(time.Now().UnixNano())
+	if ep == nil {
+		return
+	}
+	s.inFlight = true
+	r.fetcher.Submit(pulp.HTTPFetchRequest{
+		Method: "GET",
+		URL:    ep.URL + "/route-sync",
+		// The call blocks the step loop like a route request, so it gets
+		// the same bound: a slow Bananasplit fails the sync, retried next
+		// interval, rather than freezing relaying.
+		Timeout: routeRequestTimeout,
+	}, func(res fetchResult) {
+		s.inFlight = false
+		r.bananasplit.Record(ep, endpointFailed(res), time.Now().UnixNano())
+		routes, err := parseRouteSync(res)
+		if err != nil {
+			r.metrics.routeSyncErrors++
+			log.Printf("Route sync failed: %v", err)
+			return
+		}
+		r.metrics.routeSyncs++
+		r.reconcile(routes)
+	})
+}
+
+// parseRouteSync decodes a /route-sync response.
+func parseRouteSync(res fetchResult) (map[string]string, error) {
+	if res.Err != nil {
+		return nil, fmt.Errorf("route sync: %w", res.Err)
+	}
+	if res.Status != 200 {
+		return nil, fmt.Errorf("route sync failed: %d %s", res.Status, res.Body)
+	}
+	var parsed struct {
+		Routes map[string]string `json:"routes"`
+	}
+	if err := json.Unmarshal(res.Body, &parsed); err != nil {
+		return nil, fmt.Errorf("decode route sync: %w", err)
+	}
+	if parsed.Routes == nil {
+		return nil, fmt.Errorf("route sync response has no routes")
+	}
+	return parsed.Routes, nil
+}
+
+// reconcile diffs an authoritative route set from Bananasplit against
+// Router and converges, logging every difference as drift. Only bare-IP
+// routes take part, the only kind Bananasplit assigns; "ip:port" flow,
+// prefix and default routes are managed by operators through the
+// control API and left alone, and entries whose key isn't an IP are
+// skipped.
+func (r *Relay) reconcile(routes map[string]string) {
+	have := r.router.List()
+
+	want := make(map[string]string, len(routes))
+	for k, backend := range routes {
+		key, ok := bananasplitKey(k)
+		if !ok {
+			log.Printf("Route sync: skipping %q, not a player IP", k)
+			continue
+		}
+		want[key] = backend
+	}
+	keys := make([]string, 0, len(want))
+	for k := range want {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var added, changed, removed int
+	for _, key := range keys {
+		backend := want[key]
+		if err := r.checkTarget(backend); err != nil {
+			log.Printf("Route sync: skipping %s, backend %q: %v", key, backend, err)
+			continue
+		}
+		cur, ok := have[key]
+		switch {
+		case !ok:
+			log.Printf("Route drift: %s missing, adding → %s", key, backend)
+			added++
+		case cur != backend:
+			log.Printf("Route drift: %s is %s, Bananasplit has %s", key, cur, backend)
+			changed++
+		default:
+			continue
+		}
+		applyRoute(r, routeWrite{PlayerIP: key, Backend: backend, TTL: r.routeTTL})
+	}
+	for key := range have {
+		ip, ok := bananasplitKey(key)
+		if !ok {
+			continue
+		}
+		if _, ok := want[ip]; ok {
+			continue
+		}
+		log.Printf("Route drift: %s unknown to Bananasplit, removing", key)
+		r.DeleteRoute(key, "sync")
+		removed++
+	}
+
+	r.metrics.routeDrift["added"] += uint64(added)
+	r.metrics.routeDrift["changed"] += uint64(changed)
+	r.metrics.routeDrift["removed"] += uint64(removed)
+	log.Printf("Route sync: %d routes, %d added, %d changed, %d removed", len(want), added, changed, removed)
+}
+
+// bananasplitKey returns key in canonical form and reports whether it
+// is a route reconciliation and the watch feed may touch: a bare player
+// IP. Flow, prefix and default keys, and anything that doesn't parse as
+// an IP, report false.
+func bananasplitKey(key string) (string, bool) {
+	a, err := netip.ParseAddr(key)
+	if err != nil {
+		return "", false
+	}
+	return a.String(), true
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/BananaLabs-OSS/Fiber/pulp"
+)
+
+// Backoff bounds for reconnecting a failed watch stream.
+const (
+	watchMinBackoff = time.Second
+	watchMaxBackoff = time.Minute
+)
+
+// maxWatchHold caps how long a poll may ask Bananasplit to hold it
+// open. pulp's Fetch blocks the step loop, and UDP relaying with it,
+// for as long as the poll is held, so a real long-poll would freeze
+// every player for its whole hold. Until pulp gets a non-blocking
+// fetch the hold stays within one step's fetch budget, and polls are
+// spaced watchPollInterval apart instead.
+const (
+	maxWatchHold      = fetchStepBudget
+	watchPollInterval = time.Second
+)
+
+// routeWatcher long-polls Bananasplit's route change feed:
+//
+//	GET {bananasplit}/route-watch?since=<token>&timeout=<hold>
+//	→ {"token": "...", "reset": false,
+//	   "changes": [{"player_ip": "...", "backend": "..."}, ...]}
+//
+// Bananasplit may hold each poll open for at most hold (see
+// maxWatchHold), and the next poll follows watchPollInterval later, so
+// in practice this is a once-a-second poll of the feed.
+//
+// Each response carries a resume token passed as since on the next
+// poll, so no change is missed across reconnects. An empty backend
+// deletes the route. "reset": true (always the case for the first poll,
+// or after Bananasplit answers 410 Gone for an expired token) means the
+// changes are the full route set and are reconciled like a sync.
+//
+// Changes are applied exactly like POST /routes, so live sessions are
+// rebound (hot-swapped when enabled). While the stream is down Peel
+// keeps working on the pull path — unknown IPs still go through
+// /route-request and pushes to POST /routes still apply — and the
+// watcher reconnects with exponential backoff.
+type routeWatcher struct {
+	relay    *Relay
+	enabled  bool
+	hold     time.Duration
+	token    string
+	up       bool
+	inFlight bool
+	retryAt  uint64 // wall-time nanoseconds
+	backoff  time.Duration
+}
+
+func newRouteWatcher(relay *Relay, enabled bool, hold time.Duration) *routeWatcher {
+	return &routeWatcher{relay: relay, enabled: enabled, hold: hold}
+}
+
+// Step runs once per step and issues the next poll when none is in
+// flight and the poll interval or reconnect backoff has elapsed.
+func (w *routeWatcher) Step(wallNanos uint64) {
+	if !w.enabled || w.inFlight || wallNanos < w.retryAt {
+		return
+	}
+	r := w.relay
+	ep := r.bananasplit.Pick(time.Now().UnixNano())
+	if ep == nil {
+		return
+	}
+
+	u := ep.URL + "/route-watch?timeout=" + url.QueryEscape(w.hold.String())
+	if w.token != "" {
+		u += "&since=" + url.QueryEscape(w.token)
+	}
+	w.inFlight = true
+	r.fetcher.Submit(pulp.HTTPFetchRequest{
+		Method: "GET",
+		URL:    u,
+		// Bananasplit holds the poll open for up to hold; the headroom
+		// covers the round trip, and bounds how long a dead Bananasplit
+		// can stall the step.
+		Timeout: w.hold + 2*time.Second,
+	}, func(res fetchResult) {
+		w.inFlight = false
+		r.bananasplit.Record(ep, endpointFailed(res), time.Now().UnixNano())
+		if err := w.handle(res); err != nil {
+			w.down(uint64(time.Now().UnixNano()), err)
+			return
+		}
+		if !w.up {
+			log.Printf("Route watch connected")
+		}
+		w.up = true
+		w.relay.metrics.watchUp = true
+		w.backoff = 0
+		w.retryAt = uint64(time.Now().UnixNano()) + uint64(watchPollInterval)
+	})
+}
+
+// routeChange is one entry of a /route-watch response.
+type routeChange struct {
+	PlayerIP string `json:"player_ip"`
+	Backend  string `json:"backend"`
+}
+
+// handle applies one poll response.
+func (w *routeWatcher) handle(res fetchResult) error {
+	if res.Err != nil {
+		return fmt.Errorf("route watch: %w", res.Err)
+	}
+	if res.Status == 410 {
+		// Token too old for Bananasplit's change log: start over with a
+		// full reset on the next poll.
+		w.token = ""
+		return fmt.Errorf("route watch: resume token expired")
+	}
+	if res.Status != 200 {
+		return fmt.Errorf("route watch failed: %d %s", res.Status, res.Body)
+	}
+	var parsed struct {
+		Token   string        `json:"token"`
+		Reset   bool          `json:"reset"`
+		Changes []routeChange `json:"changes"`
+	}
+	if err := json.Unmarshal(res.Body, &parsed); err != nil {
+		return fmt.Errorf("decode route watch: %w", err)
+	}
+
+	r := w.relay
+	if parsed.Reset {
+		want := make(map[string]string, len(parsed.Changes))
+		for _, ch := range parsed.Changes {
+			if ch.Backend != "" {
+				want[ch.PlayerIP] = ch.Backend
+			}
+		}
+		r.reconcile(want)
+	} else {
+		for _, ch := range parsed.Changes {
+			r.applyWatchChange(ch)
+		}
+	}
+	r.metrics.watchChanges += uint64(len(parsed.Changes))
+	w.token = parsed.Token
+	return nil
+}
+
+// down records a failed poll and schedules the reconnect.
+func (w *routeWatcher) down(wallNanos uint64, err error) {
+	if w.up {
+		log.Printf("Route watch down, falling back to pull: %v", err)
+	} else {
+		log.Printf("Route watch failed: %v", err)
+	}
+	w.up = false
+	w.relay.metrics.watchUp = false
+	w.relay.metrics.watchErrors++
+	if w.backoff == 0 {
+		w.backoff = watchMinBackoff
+	} else if w.backoff *= 2; w.backoff > watchMaxBackoff {
+		w.backoff = watchMaxBackoff
+	}
+	w.retryAt = wallNanos + uint64(w.backoff)
+}
+
+// applyWatchChange applies one incremental change from the feed. Like
+// reconcile it only touches bare-IP routes; a change for a flow,
+// prefix or default route, or for a key that isn't an IP, is dropped.
+func (r *Relay) applyWatchChange(ch routeChange) {
+	key, ok := bananasplitKey(ch.PlayerIP)
+	if !ok {
+		log.Printf("Route watch: skipping %q, not a player IP", ch.PlayerIP)
+		return
+	}
+	if ch.Backend == "" {
+		if _, ok := r.router.Get(key); !ok {
+			return
+		}
+		r.DeleteRoute(key, "watch")
+		return
+	}
+	if err := r.checkTarget(ch.Backend); err != nil {
+		log.Printf("Route watch: skipping %s, backend %q: %v", key, ch.Backend, err)
+		return
+	}
+	if cur, ok := r.router.Get(key); ok && cur == ch.Backend {
+		return
+	}
+	applyRoute(r, routeWrite{PlayerIP: key, Backend: ch.Backend, TTL: r.routeTTL})
+}
@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+// newTestRelay returns an unstarted relay with an open destination
+// policy and no Bananasplit.
+func newTestRelay(t *testing.T) *Relay {
+	t.Helper()
+	dest, err := newDestPolicy(":5520", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(appConfig{ListenAddr: ":5520", Dest: dest})
+}
+
+func TestApplyWatchChangeKeys(t *testing.T) {
+	operator := map[string]string{
+		"default":          "10.0.9.1:5520",
+		"10.0.0.0/8":       "10.0.9.2:5520",
+		"203.0.113.7:4000": "10.0.9.3:5520",
+		"203.0.113.50":     "10.0.9.4:5520",
+		"2001:db8::1":      "10.0.9.5:5520",
+	}
+
+	tests := []struct {
+		name string
+		ch   routeChange
+		want map[string]string // routes changed from operator; "" deleted
+	}{
+		{"delete default", routeChange{PlayerIP: "default"}, nil},
+		{"delete prefix", routeChange{PlayerIP: "10.0.0.0/8"}, nil},
+		{"delete unmasked prefix", routeChange{PlayerIP: "10.1.2.3/8"}, nil},
+		{"delete flow", routeChange{PlayerIP: "203.0.113.7:4000"}, nil},
+		{"set default", routeChange{PlayerIP: "default", Backend: "10.0.8.1:5520"}, nil},
+		{"set prefix", routeChange{PlayerIP: "192.168.1.2/8", Backend: "10.0.8.1:5520"}, nil},
+		{"set garbage", routeChange{PlayerIP: "garbage", Backend: "10.0.8.1:5520"}, nil},
+		{"set empty", routeChange{PlayerIP: "", Backend: "10.0.8.1:5520"}, nil},
+
+		{"set ip", routeChange{PlayerIP: "203.0.113.51", Backend: "10.0.8.1:5520"},
+			map[string]string{"203.0.113.51": "10.0.8.1:5520"}},
+		{"change ip", routeChange{PlayerIP: "203.0.113.50", Backend: "10.0.8.1:5520"},
+			map[string]string{"203.0.113.50": "10.0.8.1:5520"}},
+		{"delete ip", routeChange{PlayerIP: "203.0.113.50"},
+			map[string]string{"203.0.113.50": ""}},
+		{"delete non-canonical ipv6", routeChange{PlayerIP: "2001:DB8:0::1"},
+			map[string]string{"2001:db8::1": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRelay(t)
+			for k, b := range operator {
+				r.router.Set(k, b)
+			}
+			r.applyWatchChange(tt.ch)
+
+			want := make(map[string]string)
+			for k, b := range operator {
+				want[k] = b
+			}
+			for k, b := range tt.want {
+				if b == "" {
+					delete(want, k)
+				} else {
+					want[k] = b
+				}
+			}
+			got := r.router.List()
+			if len(got) != len(want) {
+				t.Errorf("routes = %v, want %v", got, want)
+				return
+			}
+			for k, b := range want {
+				if got[k] != b {
+					t.Errorf("routes = %v, want %v", got, want)
+					return
+				}
+			}
+		})
+	}
+}
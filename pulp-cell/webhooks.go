@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/BananaLabs-OSS/Fiber/pulp"
+)
+
+// Retry backoff bounds for failed webhook deliveries.
+const (
+	webhookMinBackoff = time.Second
+	webhookMaxBackoff = time.Minute
+)
+
+// webhookSender delivers selected relay events to an HTTP endpoint
+// (Bananasplit) so it learns when players appear, move or leave.
+//
+// Events are appended to a bounded outbox by an event-bus subscriber
+// and sent in batches of up to batchSize as
+//
+//	POST {url}  {"events": [<relayEvent>, ...]}
+//
+// at most one request at a time, via the fetch queue — so it is made
+// between packets, not while handling one, but still blocks the step
+// for up to 5s like every pulp Fetch. A failed batch is retried with
+// exponential backoff up to maxRetries times and then dropped. When
+// the outbox is full the oldest event is discarded. Drops are counted
+// in metrics and logged.
+type webhookSender struct {
+	relay      *Relay
+	url        string
+	types      map[string]bool
+	batchSize  int
+	flushEvery time.Duration
+	maxOutbox  int
+	maxRetries int
+
+	outbox    []relayEvent
+	inFlight  bool
+	evicted   int // outbox head entries dropped while a batch was in flight
+	attempts  int
+	backoff   time.Duration
+	nextFlush uint64 // wall-time nanoseconds
+}
+
+func newWebhookSender(relay *Relay, cfg appConfig) *webhookSender {
+	w := &webhookSender{
+		relay:      relay,
+		url:        cfg.WebhookURL,
+		types:      make(map[string]bool, len(cfg.WebhookEvents)),
+		batchSize:  cfg.WebhookBatch,
+		flushEvery: cfg.WebhookFlush,
+		maxOutbox:  cfg.WebhookOutbox,
+		maxRetries: cfg.WebhookRetries,
+	}
+	for _, t := range cfg.WebhookEvents {
+		w.types[t] = true
+	}
+	if w.url != "" {
+		relay.events.Subscribe(w.enqueue)
+	}
+	return w
+}
+
+// enqueue is the event-bus subscriber: it only buffers.
+func (w *webhookSender) enqueue(ev relayEvent) {
+	if !w.types[ev.Type] {
+		return
+	}
+	if len(w.outbox) >= w.maxOutbox {
+		w.outbox = w.outbox[1:]
+		w.relay.metrics.webhookDropped++
+		if w.inFlight {
+			w.evicted++
+		}
+	}
+	w.outbox = append(w.outbox, ev)
+}
+
+// Step runs once per step and sends the next batch when the previous
+// one has finished and either a full batch is waiting or the flush
+// interval (or retry backoff) has elapsed.
+func (w *webhookSender) Step(wallNanos uint64) {
+	if w.url == "" || w.inFlight || len(w.outbox) == 0 {
+		return
+	}
+	if len(w.outbox) < w.batchSize && wallNanos < w.nextFlush {
+		return
+	}
+	if w.attempts > 0 && wallNanos < w.nextFlush {
+		return
+	}
+
+	n := min(len(w.outbox), w.batchSize)
+	batch := w.outbox[:n:n]
+	body, err := json.Marshal(map[string]any{"events": batch})
+	if err != nil {
+		log.Printf("Webhook encode failed: %v", err)
+		w.outbox = w.outbox[n:]
+		return
+	}
+
+	w.inFlight = true
+	w.relay.fetcher.Submit(pulp.HTTPFetchRequest{
+		Method:  "POST",
+		URL:     w.url,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    body,
+		Timeout: 5 * time.Second,
+	}, func(res fetchResult) {
+		w.inFlight = false
+		now := uint64(time.Now().UnixNano())
+		// Events enqueued meanwhile sit behind the batch; only overflow
+		// evictions can have eaten into its head.
+		remaining := max(n-w.evicted, 0)
+		w.evicted = 0
+		if err := webhookError(res); err != nil {
+			w.retry(now, remaining, err)
+			return
+		}
+		w.outbox = w.outbox[remaining:]
+		w.relay.metrics.webhookSent += uint64(remaining)
+		w.attempts = 0
+		w.backoff = 0
+		w.nextFlush = now + uint64(w.flushEvery)
+	})
+}
+
+// retry schedules another attempt for the head batch (its n events
+// still in the outbox), or drops it after maxRetries failures.
+func (w *webhookSender) retry(now uint64, n int, err error) {
+	w.attempts++
+	w.relay.metrics.webhookErrors++
+	if w.attempts > w.maxRetries {
+		log.Printf("Webhook delivery failed %d times, dropping %d events: %v", w.attempts, n, err)
+		w.outbox = w.outbox[n:]
+		w.relay.metrics.webhookDropped += uint64(n)
+		w.attempts = 0
+		w.backoff = 0
+		w.nextFlush = now + uint64(w.flushEvery)
+		return
+	}
+	if w.backoff == 0 {
+		w.backoff = webhookMinBackoff
+	} else if w.backoff *= 2; w.backoff > webhookMaxBackoff {
+		w.backoff = webhookMaxBackoff
+	}
+	log.Printf("Webhook delivery failed (attempt %d), retrying in %s: %v", w.attempts, w.backoff, err)
+	w.nextFlush = now + uint64(w.backoff)
+}
+
+// webhookError reports a failed delivery: a transport error or any
+// non-2xx status.
+func webhookError(res fetchResult) error {
+	if res.Err != nil {
+		return fmt.Errorf("webhook: %w", res.Err)
+	}
+	if res.Status < 200 || res.Status > 299 {
+		return fmt.Errorf("webhook failed: %d %s", res.Status, res.Body)
+	}
+	return nil
+}